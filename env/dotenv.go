@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Precedence between dotenv files and the process environment
+const (
+	// precedenceProcess keeps process variables over file values, like
+	// docker compose does for .env files
+	precedenceProcess = "process"
+	// precedenceFile lets file values override the process environment
+	precedenceFile = "file"
+)
+
+// dotenvVar is a single assignment from a dotenv file
+type dotenvVar struct {
+	key   string
+	value string
+}
+
+// loadEnvironment builds the variables visible to a request: the process
+// environment, layered with the dotenv files named by context.env_file.
+func loadEnvironment(context map[string]any) (map[string]string, error) {
+	vars := processEnvironment()
+
+	files := getStringList(context, "env_file")
+	if len(files) == 0 {
+		return vars, nil
+	}
+
+	precedence := getString(context, "env_precedence", precedenceProcess)
+	for _, path := range files {
+		parsed, err := readDotenv(resolvePath(context, path), vars, precedence)
+		if err != nil {
+			return nil, err
+		}
+		layerDotenv(vars, parsed, precedence)
+	}
+
+	return vars, nil
+}
+
+func processEnvironment() map[string]string {
+	vars := make(map[string]string)
+	for _, e := range os.Environ() {
+		if key, value, ok := strings.Cut(e, "="); ok {
+			vars[key] = value
+		}
+	}
+	return vars
+}
+
+// layerDotenv merges parsed file variables into vars according to precedence
+func layerDotenv(vars map[string]string, parsed []dotenvVar, precedence string) {
+	process := processEnvironment()
+	for _, v := range parsed {
+		if _, inProcess := process[v.key]; inProcess && precedence == precedenceProcess {
+			continue
+		}
+		vars[v.key] = v.value
+	}
+}
+
+// resolvePath resolves a relative path against the directory of the
+// document being rendered (context.file), falling back to the working
+// directory.
+func resolvePath(context map[string]any, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	if doc := getString(context, "file", ""); doc != "" {
+		return filepath.Join(filepath.Dir(doc), path)
+	}
+	return path
+}
+
+// readDotenv reads and parses a dotenv file layered over env
+func readDotenv(path string, env map[string]string, precedence string) ([]dotenvVar, error) {
+	if precedence != precedenceProcess && precedence != precedenceFile {
+		return nil, fmt.Errorf("env_precedence must be %s or %s", precedenceProcess, precedenceFile)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %v", err)
+	}
+
+	vars, err := parseDotenv(string(data), env, precedence)
+	if err != nil {
+		return nil, newError(errorCode(err), "%s: %v", path, err)
+	}
+
+	return vars, nil
+}
+
+// parseDotenv parses dotenv syntax:
+//
+//	# comment
+//	export KEY=value       # optional export prefix, trailing comment
+//	KEY='literal $value'   # single quotes: no escapes or references
+//	KEY="line\nbreak"      # double quotes: escapes and ${VAR} references
+//	KEY="spans
+//	lines"                 # quoted values may span lines
+//	KEY=${OTHER:-default}/path
+//
+// Unquoted and double-quoted values get shell parameter expansion (see
+// shellExpander). References resolve to earlier assignments in the file or
+// to env, with process variables winning under precedenceProcess, and go
+// through the policy like any other read.
+func parseDotenv(data string, env map[string]string, precedence string) ([]dotenvVar, error) {
+	var vars []dotenvVar
+	process := processEnvironment()
+	defined := make(map[string]string)
+	resolve := func(key string) (string, bool, error) {
+		if !allowed(key) {
+			return "", false, newError(codePermissionDenied, "access to environment variable %s is denied by policy", key)
+		}
+		if v, ok := process[key]; ok && precedence == precedenceProcess {
+			return v, true, nil
+		}
+		if v, ok := defined[key]; ok {
+			return v, true, nil
+		}
+		v, ok := env[key]
+		return v, ok, nil
+	}
+
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	line := 1
+	for len(data) > 0 {
+		var raw string
+		raw, data, _ = strings.Cut(data, "\n")
+		startLine := line
+		line++
+
+		trimmed := strings.TrimSpace(raw)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		trimmed = strings.TrimPrefix(trimmed, "export ")
+
+		key, rest, ok := strings.Cut(trimmed, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !isEnvName(key) {
+			return nil, fmt.Errorf("line %d: expected KEY=value", startLine)
+		}
+		rest = strings.TrimLeft(rest, " \t")
+
+		var value string
+		var err error
+		switch {
+		case strings.HasPrefix(rest, "'"), strings.HasPrefix(rest, `"`):
+			quote := rest[0]
+			body := rest[1:]
+			// Keep consuming lines until the closing quote
+			end := closingQuote(body, quote)
+			for end < 0 && len(data) > 0 {
+				var next string
+				next, data, _ = strings.Cut(data, "\n")
+				line++
+				body += "\n" + next
+				end = closingQuote(body, quote)
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated quoted value", startLine)
+			}
+			if trailing := strings.TrimSpace(body[end+1:]); trailing != "" && !strings.HasPrefix(trailing, "#") {
+				return nil, fmt.Errorf("line %d: unexpected characters after quoted value", startLine)
+			}
+			body = body[:end]
+			if quote == '\'' {
+				value = body
+			} else {
+				value, err = expandShell(unescapeDoubleQuoted(body), resolve, false)
+			}
+		default:
+			// Unquoted: a # preceded by whitespace starts a comment
+			if i := strings.Index(rest, " #"); i >= 0 {
+				rest = rest[:i]
+			}
+			if i := strings.Index(rest, "\t#"); i >= 0 {
+				rest = rest[:i]
+			}
+			value, err = expandShell(strings.TrimSpace(rest), resolve, false)
+		}
+		if err != nil {
+			return nil, newError(errorCode(err), "line %d: %v", startLine, err)
+		}
+
+		vars = append(vars, dotenvVar{key: key, value: value})
+		defined[key] = value
+	}
+
+	return vars, nil
+}
+
+// closingQuote returns the index of the unescaped closing quote in s, or -1
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && quote == '"' {
+			i++
+			continue
+		}
+		if s[i] == quote {
+			return i
+		}
+	}
+	return -1
+}
+
+func unescapeDoubleQuoted(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case '"', '\\':
+			sb.WriteByte(s[i])
+		case '$':
+			// Escaped dollar: keep it escaped so expandShell leaves it alone
+			sb.WriteString(`\$`)
+		default:
+			sb.WriteByte('\\')
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String()
+}
+
+func isEnvName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isEnvNameByte(s[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func isEnvNameByte(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
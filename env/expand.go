@@ -0,0 +1,441 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// shellExpander performs POSIX/bash parameter expansion:
+//
+//	$VAR ${VAR}            value
+//	${VAR:-word}           word if VAR is unset or empty (${VAR-word}: unset only)
+//	${VAR:=word}           as :-, and VAR keeps word for the rest of the text
+//	${VAR:?message}        error if VAR is unset or empty (${VAR?message}: unset only)
+//	${VAR:+word}           word if VAR is set and non-empty (${VAR+word}: set)
+//	${#VAR}                length in characters
+//	${VAR:offset:length}   substring; negative offsets need a space: ${VAR: -2}
+//	${VAR#pat} ${VAR##pat} remove shortest/longest matching prefix
+//	${VAR%pat} ${VAR%%pat} remove shortest/longest matching suffix
+//	${VAR/pat/repl}        replace first match (// all, /# prefix, /% suffix)
+//	${VAR^^} ${VAR,,}      upper/lower case (^ and , for the first character)
+//
+// Patterns are shell globs (* ? [...]). Words and patterns are themselves
+// expanded. $$ and \$ produce a literal dollar sign.
+type shellExpander struct {
+	lookup   func(string) (string, bool, error)
+	assigned map[string]string
+	// strict fails on references to unset variables, like set -u
+	strict bool
+}
+
+func expandShell(s string, lookup func(string) (string, bool, error), strict bool) (string, error) {
+	e := &shellExpander{lookup: lookup, assigned: map[string]string{}, strict: strict}
+	return e.expand(s)
+}
+
+func (e *shellExpander) get(name string) (string, bool, error) {
+	if v, ok := e.assigned[name]; ok {
+		return v, true, nil
+	}
+	return e.lookup(name)
+}
+
+// value returns the value of a plain reference, enforcing strict mode
+func (e *shellExpander) value(name string) (string, error) {
+	v, ok, err := e.get(name)
+	if err != nil {
+		return "", err
+	}
+	if !ok && e.strict {
+		return "", newError(codeNotFound, "%s: unbound variable", name)
+	}
+	return v, nil
+}
+
+func (e *shellExpander) expand(s string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '$':
+			sb.WriteByte('$')
+			i++
+		case s[i] != '$' || i+1 == len(s):
+			sb.WriteByte(s[i])
+		case s[i+1] == '$':
+			sb.WriteByte('$')
+			i++
+		case s[i+1] == '{':
+			end := closingBrace(s, i+2)
+			if end < 0 {
+				return "", newError(codeInvalidParam, "unterminated ${ in %q", s)
+			}
+			v, err := e.braced(s[i+2 : end])
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(v)
+			i = end
+		default:
+			j := i + 1
+			for j < len(s) && isEnvNameByte(s[j], j == i+1) {
+				j++
+			}
+			if j == i+1 {
+				sb.WriteByte('$')
+				continue
+			}
+			v, err := e.value(s[i+1 : j])
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(v)
+			i = j - 1
+		}
+	}
+	return sb.String(), nil
+}
+
+// closingBrace returns the index of the } closing a ${ whose body starts at
+// start, skipping nested ${...} and escaped characters, or -1
+func closingBrace(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			depth++
+			i++
+		case s[i] == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// braced expands the body of a ${...} expression
+func (e *shellExpander) braced(expr string) (string, error) {
+	if len(expr) > 1 && expr[0] == '#' && isEnvName(expr[1:]) {
+		v, err := e.value(expr[1:])
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(utf8.RuneCountInString(v)), nil
+	}
+
+	n := 0
+	for n < len(expr) && isEnvNameByte(expr[n], n == 0) {
+		n++
+	}
+	if n == 0 {
+		return "", badSubstitution(expr)
+	}
+	name, op := expr[:n], expr[n:]
+	if op == "" {
+		return e.value(name)
+	}
+
+	v, set, err := e.get(name)
+	if err != nil {
+		return "", err
+	}
+	colon := op[0] == ':'
+	rest := strings.TrimPrefix(op, ":")
+	// With a colon the operators treat empty values like unset ones
+	present := set && (!colon || v != "")
+
+	if rest != "" {
+		switch rest[0] {
+		case '-':
+			if present {
+				return v, nil
+			}
+			return e.expand(rest[1:])
+		case '=':
+			if present {
+				return v, nil
+			}
+			word, err := e.expand(rest[1:])
+			if err != nil {
+				return "", err
+			}
+			e.assigned[name] = word
+			return word, nil
+		case '?':
+			if present {
+				return v, nil
+			}
+			message, err := e.expand(rest[1:])
+			if err != nil {
+				return "", err
+			}
+			if message == "" {
+				message = "parameter null or not set"
+			}
+			return "", newError(codeNotFound, "%s: %s", name, message)
+		case '+':
+			if present {
+				return e.expand(rest[1:])
+			}
+			return "", nil
+		}
+	}
+
+	if !set && e.strict {
+		return "", newError(codeNotFound, "%s: unbound variable", name)
+	}
+
+	if colon {
+		return e.substring(expr, v, rest)
+	}
+
+	switch {
+	case strings.HasPrefix(op, "##"):
+		return e.trim(v, op[2:], true, true)
+	case strings.HasPrefix(op, "#"):
+		return e.trim(v, op[1:], true, false)
+	case strings.HasPrefix(op, "%%"):
+		return e.trim(v, op[2:], false, true)
+	case strings.HasPrefix(op, "%"):
+		return e.trim(v, op[1:], false, false)
+	case strings.HasPrefix(op, "/"):
+		return e.replace(v, op[1:])
+	case op == "^^":
+		return strings.ToUpper(v), nil
+	case op == ",,":
+		return strings.ToLower(v), nil
+	case op == "^", op == ",":
+		r, size := utf8.DecodeRuneInString(v)
+		if size == 0 {
+			return v, nil
+		}
+		if op == "^" {
+			return strings.ToUpper(string(r)) + v[size:], nil
+		}
+		return strings.ToLower(string(r)) + v[size:], nil
+	}
+
+	return "", badSubstitution(expr)
+}
+
+// substring implements ${VAR:offset} and ${VAR:offset:length} on characters
+func (e *shellExpander) substring(expr, v, spec string) (string, error) {
+	offsetSpec, lengthSpec, hasLength := strings.Cut(spec, ":")
+	offset, err := strconv.Atoi(strings.TrimSpace(offsetSpec))
+	if err != nil {
+		return "", badSubstitution(expr)
+	}
+
+	runes := []rune(v)
+	if offset < 0 {
+		offset += len(runes)
+	}
+	if offset < 0 || offset > len(runes) {
+		return "", nil
+	}
+
+	end := len(runes)
+	if hasLength {
+		length, err := strconv.Atoi(strings.TrimSpace(lengthSpec))
+		if err != nil {
+			return "", badSubstitution(expr)
+		}
+		if length < 0 {
+			// A negative length is an offset from the end
+			end = len(runes) + length
+		} else {
+			end = min(offset+length, len(runes))
+		}
+		if end < offset {
+			return "", newError(codeInvalidParam, "%s: substring expression < 0", expr)
+		}
+	}
+
+	return string(runes[offset:end]), nil
+}
+
+// trim removes the shortest or longest prefix or suffix of v matching pattern
+func (e *shellExpander) trim(v, pattern string, prefix, longest bool) (string, error) {
+	re, err := e.glob(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	cuts := runeBoundaries(v)
+	if longest == prefix {
+		// Longest prefix or shortest suffix: try cut points from the end
+		for i, j := 0, len(cuts)-1; i < j; i, j = i+1, j-1 {
+			cuts[i], cuts[j] = cuts[j], cuts[i]
+		}
+	}
+
+	for _, cut := range cuts {
+		if prefix && re.MatchString(v[:cut]) {
+			return v[cut:], nil
+		}
+		if !prefix && re.MatchString(v[cut:]) {
+			return v[:cut], nil
+		}
+	}
+	return v, nil
+}
+
+// replace implements ${VAR/pat/repl}, ${VAR//pat/repl}, ${VAR/#pat/repl} and
+// ${VAR/%pat/repl} with longest matches
+func (e *shellExpander) replace(v, spec string) (string, error) {
+	mode := byte(0)
+	if spec != "" && (spec[0] == '/' || spec[0] == '#' || spec[0] == '%') {
+		mode, spec = spec[0], spec[1:]
+	}
+
+	pattern, repl := spec, ""
+	if i := unescapedSlash(spec); i >= 0 {
+		pattern, repl = spec[:i], strings.ReplaceAll(spec[i+1:], `\/`, "/")
+	}
+
+	repl, err := e.expand(repl)
+	if err != nil {
+		return "", err
+	}
+	re, err := e.glob(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	cuts := runeBoundaries(v)
+	switch mode {
+	case '#':
+		for i := len(cuts) - 1; i >= 0; i-- {
+			if re.MatchString(v[:cuts[i]]) {
+				return repl + v[cuts[i]:], nil
+			}
+		}
+		return v, nil
+	case '%':
+		for _, cut := range cuts {
+			if re.MatchString(v[cut:]) {
+				return v[:cut] + repl, nil
+			}
+		}
+		return v, nil
+	}
+
+	if pattern == "" {
+		return v, nil
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(cuts)-1; i++ {
+		start, matched := cuts[i], false
+		for j := len(cuts) - 1; j > i; j-- {
+			if re.MatchString(v[start:cuts[j]]) {
+				sb.WriteString(repl)
+				if mode != '/' {
+					sb.WriteString(v[cuts[j]:])
+					return sb.String(), nil
+				}
+				i, matched = j-1, true
+				break
+			}
+		}
+		if !matched {
+			sb.WriteString(v[start:cuts[i+1]])
+		}
+	}
+	return sb.String(), nil
+}
+
+// glob expands pattern and compiles it as an anchored shell glob
+func (e *shellExpander) glob(pattern string) (*regexp.Regexp, error) {
+	pattern, err := e.expand(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return regexp.Compile("^(?s:" + globToRegexp(pattern) + ")$")
+}
+
+// globToRegexp translates the shell glob operators * ? [...] and \x escapes
+func globToRegexp(pattern string) string {
+	var sb strings.Builder
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+			}
+			sb.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case '[':
+			j := i + 1
+			if j < len(runes) && (runes[j] == '!' || runes[j] == '^') {
+				j++
+			}
+			if j < len(runes) && runes[j] == ']' {
+				j++
+			}
+			for j < len(runes) && runes[j] != ']' {
+				j++
+			}
+			if j >= len(runes) {
+				sb.WriteString(`\[`)
+				continue
+			}
+			sb.WriteByte('[')
+			k := i + 1
+			if runes[k] == '!' || runes[k] == '^' {
+				sb.WriteByte('^')
+				k++
+			}
+			for ; k < j; k++ {
+				if runes[k] == '-' {
+					sb.WriteByte('-')
+				} else {
+					sb.WriteString(regexp.QuoteMeta(string(runes[k])))
+				}
+			}
+			sb.WriteByte(']')
+			i = j
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return sb.String()
+}
+
+// unescapedSlash returns the index of the first / not preceded by a
+// backslash, or -1
+func unescapedSlash(s string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == '/' {
+			return i
+		}
+	}
+	return -1
+}
+
+// runeBoundaries returns the byte offsets of every character boundary in s,
+// including 0 and len(s)
+func runeBoundaries(s string) []int {
+	cuts := make([]int, 0, len(s)+1)
+	for i := range s {
+		cuts = append(cuts, i)
+	}
+	return append(cuts, len(s))
+}
+
+func badSubstitution(expr string) error {
+	return newError(codeInvalidParam, "${%s}: bad substitution", expr)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Key case transforms for list()
+const (
+	caseKeep  = "keep"
+	caseLower = "lower"
+	caseUpper = "upper"
+	caseCamel = "camel"
+)
+
+// listShape controls how list() turns variable names into result keys:
+// strip the prefix, split on a nesting separator, then transform the case
+// of each segment. APP__DB__HOST with prefix "APP__", nest "__" and case
+// "lower" becomes {db: {host: ...}}, as Go config loaders bind environment
+// variables to nested structs.
+type listShape struct {
+	stripPrefix bool
+	nest        string
+	keyCase     string
+	match       *regexp.Regexp
+}
+
+func newListShape(params map[string]any) (*listShape, error) {
+	shape := &listShape{
+		stripPrefix: getBool(params, "strip_prefix", false),
+		nest:        getString(params, "nest", ""),
+		keyCase:     getString(params, "case", caseKeep),
+	}
+
+	switch shape.keyCase {
+	case caseKeep, caseLower, caseUpper, caseCamel:
+	default:
+		return nil, newError(codeInvalidParam, "case must be %s, %s, %s or %s", caseKeep, caseLower, caseUpper, caseCamel)
+	}
+
+	if pattern := getString(params, "match", ""); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, newError(codeInvalidParam, "invalid match pattern: %v", err)
+		}
+		shape.match = re
+	}
+
+	return shape, nil
+}
+
+// insert places value in result under the shaped form of name. sources
+// records which variable produced each path so collisions can be reported.
+func (s *listShape) insert(result map[string]any, sources map[string]string, name, prefix, value string) error {
+	key := name
+	if s.stripPrefix {
+		key = strings.TrimPrefix(key, prefix)
+	}
+
+	var segments []string
+	if s.nest != "" {
+		for _, segment := range strings.Split(key, s.nest) {
+			if segment != "" {
+				segments = append(segments, s.transform(segment))
+			}
+		}
+	} else if key != "" {
+		segments = []string{s.transform(key)}
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+
+	node := result
+	for i, segment := range segments {
+		path := strings.Join(segments[:i+1], ".")
+		if i == len(segments)-1 {
+			if _, exists := node[segment]; exists {
+				return s.conflict(sources[path], name, path)
+			}
+			node[segment] = value
+			sources[path] = name
+			return nil
+		}
+
+		switch child := node[segment].(type) {
+		case nil:
+			next := make(map[string]any)
+			node[segment] = next
+			sources[path] = name
+			node = next
+		case map[string]any:
+			node = child
+		default:
+			return s.conflict(sources[path], name, path)
+		}
+	}
+	return nil
+}
+
+func (s *listShape) conflict(first, second, path string) error {
+	return newError(codeInvalidParam, "environment variables %s and %s both map to %q", first, second, path)
+}
+
+// transform applies the key case to one name segment
+func (s *listShape) transform(segment string) string {
+	switch s.keyCase {
+	case caseLower:
+		return strings.ToLower(segment)
+	case caseUpper:
+		return strings.ToUpper(segment)
+	case caseCamel:
+		return camelCase(segment)
+	default:
+		return segment
+	}
+}
+
+// camelCase turns DB_HOST_NAME into dbHostName
+func camelCase(s string) string {
+	var sb strings.Builder
+	for _, word := range strings.Split(s, "_") {
+		if word == "" {
+			continue
+		}
+		word = strings.ToLower(word)
+		if sb.Len() > 0 {
+			word = strings.ToUpper(word[:1]) + word[1:]
+		}
+		sb.WriteString(word)
+	}
+	return sb.String()
+}
@@ -2,11 +2,47 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// Error codes reported in the code field of error responses
+const (
+	codeInvalidParam     = "INVALID_PARAM"
+	codeNotFound         = "NOT_FOUND"
+	codePermissionDenied = "PERMISSION_DENIED"
+)
+
+// codedError is an error carrying a protocol error code
+type codedError struct {
+	code    string
+	message string
+}
+
+func (e *codedError) Error() string {
+	return e.message
+}
+
+func newError(code, format string, args ...any) error {
+	return &codedError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// errorCode returns the protocol code of err, or "" if it has none
+func errorCode(err error) string {
+	var ce *codedError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	return ""
+}
+
 type Request struct {
 	Function string         `json:"function"`
 	Params   map[string]any `json:"params"`
@@ -17,8 +53,13 @@ type Response struct {
 	Value any    `json:"value"`
 	Type  string `json:"type"`
 	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
 }
 
+// environ holds the variables visible to the request: the process
+// environment layered with any dotenv files from context.env_file
+var environ map[string]string
+
 func main() {
 	var req Request
 	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
@@ -26,46 +67,92 @@ func main() {
 		return
 	}
 
+	// Policies come first so references inside dotenv files are checked
+	var err error
+	policies, err = loadPolicies(req.Context)
+	if err != nil {
+		sendError(err.Error())
+		return
+	}
+
+	environ, err = loadEnvironment(req.Context)
+	if err != nil {
+		sendErrorCode(err.Error(), errorCode(err))
+		return
+	}
+
 	var result any
 	var resultType string
-	var err error
 
 	switch req.Function {
 	case "get":
-		result, resultType, err = handleGet(req.Params)
+		result, resultType, err = handleGet(req.Params, req.Context)
 	case "has":
 		result, resultType, err = handleHas(req.Params)
 	case "list":
-		result, resultType, err = handleList(req.Params)
+		if _, ok := req.Params["key"]; ok {
+			result, resultType, err = handleTypedList(req.Params)
+		} else {
+			result, resultType, err = handleList(req.Params, req.Context)
+		}
+	case "int":
+		result, resultType, err = handleInt(req.Params)
+	case "float":
+		result, resultType, err = handleFloat(req.Params)
+	case "bool":
+		result, resultType, err = handleBool(req.Params)
+	case "duration":
+		result, resultType, err = handleDuration(req.Params)
+	case "json":
+		result, resultType, err = handleJSON(req.Params)
+	case "url":
+		result, resultType, err = handleURL(req.Params)
 	case "expand":
-		result, resultType, err = handleExpand(req.Params)
+		result, resultType, err = handleExpand(req.Params, req.Context)
+	case "load":
+		result, resultType, err = handleLoad(req.Params, req.Context)
 	default:
 		sendError(fmt.Sprintf("Unknown function: %s", req.Function))
 		return
 	}
 
 	if err != nil {
-		sendError(err.Error())
+		sendErrorCode(err.Error(), errorCode(err))
 		return
 	}
 
 	sendResponse(result, resultType)
 }
 
-func handleGet(params map[string]any) (any, string, error) {
+func handleGet(params map[string]any, context map[string]any) (any, string, error) {
 	key := getString(params, "key", "")
 	if key == "" {
 		return nil, "", fmt.Errorf("key parameter required")
 	}
 
-	defaultValue := getString(params, "default", "")
-	value := os.Getenv(key)
+	redact, err := newRedactor(params, context)
+	if err != nil {
+		return nil, "", err
+	}
+
+	value, exists, err := lookupEnv(key)
+	if err != nil {
+		return nil, "", err
+	}
+	if exists && value == "" && getBool(params, "default_if_empty", false) {
+		exists = false
+	}
 
-	if value == "" && defaultValue != "" {
-		return defaultValue, "string", nil
+	if !exists {
+		if defaultValue, ok := params["default"].(string); ok {
+			return defaultValue, "string", nil
+		}
+		if getBool(params, "required", false) {
+			return nil, "", newError(codeNotFound, "environment variable %s is required", key)
+		}
 	}
 
-	return value, "string", nil
+	return redact.apply(key, value), "string", nil
 }
 
 func handleHas(params map[string]any) (any, string, error) {
@@ -74,39 +161,265 @@ func handleHas(params map[string]any) (any, string, error) {
 		return nil, "", fmt.Errorf("key parameter required")
 	}
 
-	_, exists := os.LookupEnv(key)
+	_, exists, err := lookupEnv(key)
+	if err != nil {
+		return nil, "", err
+	}
 	return exists, "bool", nil
 }
 
-func handleList(params map[string]any) (any, string, error) {
+func handleList(params map[string]any, context map[string]any) (any, string, error) {
 	prefix := getString(params, "prefix", "")
 
-	env := os.Environ()
-	result := make(map[string]string)
+	redact, err := newRedactor(params, context)
+	if err != nil {
+		return nil, "", err
+	}
 
-	for _, e := range env {
-		parts := strings.SplitN(e, "=", 2)
-		if len(parts) == 2 {
-			key := parts[0]
-			if prefix == "" || strings.HasPrefix(key, prefix) {
-				result[key] = parts[1]
-			}
+	shape, err := newListShape(params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	keys := make([]string, 0, len(environ))
+	for key := range environ {
+		// Denied variables are left out rather than failing the whole listing
+		if !allowed(key) {
+			continue
+		}
+		if prefix == "" || strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	// Sorted so conflicts between keys are reported consistently
+	sort.Strings(keys)
+
+	result := make(map[string]any)
+	sources := make(map[string]string)
+	for _, key := range keys {
+		if shape.match != nil && !shape.match.MatchString(key) {
+			continue
+		}
+		if err := shape.insert(result, sources, key, prefix, redact.apply(key, environ[key])); err != nil {
+			return nil, "", err
 		}
 	}
 
 	return result, "block", nil
 }
 
-func handleExpand(params map[string]any) (any, string, error) {
-	text := getString(params, "text", "")
+func handleExpand(params map[string]any, context map[string]any) (any, string, error) {
+	text := getString(params, "template", getString(params, "text", ""))
 	if text == "" {
-		return nil, "", fmt.Errorf("text parameter required")
+		return nil, "", fmt.Errorf("template parameter required")
+	}
+
+	redact, err := newRedactor(params, context)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Values are redacted as they are substituted, so operators such as
+	// ${#VAR} see the redacted value
+	lookup := func(key string) (string, bool, error) {
+		value, ok, err := lookupEnv(key)
+		return redact.apply(key, value), ok, err
 	}
 
-	expanded := os.ExpandEnv(text)
+	expanded, err := expandShell(text, lookup, getBool(params, "strict", false))
+	if err != nil {
+		return nil, "", err
+	}
 	return expanded, "string", nil
 }
 
+func handleInt(params map[string]any) (any, string, error) {
+	return lookupTyped(params, "integer", "int", func(value string) (any, error) {
+		return strconv.ParseInt(value, 0, 64)
+	})
+}
+
+func handleFloat(params map[string]any) (any, string, error) {
+	return lookupTyped(params, "float", "float", func(value string) (any, error) {
+		return strconv.ParseFloat(value, 64)
+	})
+}
+
+func handleBool(params map[string]any) (any, string, error) {
+	return lookupTyped(params, "boolean", "bool", func(value string) (any, error) {
+		switch strings.ToLower(value) {
+		case "true", "1", "yes", "on", "y", "t":
+			return true, nil
+		case "false", "0", "no", "off", "n", "f":
+			return false, nil
+		}
+		return nil, fmt.Errorf("expected true/false, 1/0, yes/no or on/off")
+	})
+}
+
+func handleDuration(params map[string]any) (any, string, error) {
+	return lookupTyped(params, "duration", "dur", func(value string) (any, error) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, err
+		}
+		return d.String(), nil
+	})
+}
+
+func handleTypedList(params map[string]any) (any, string, error) {
+	sep := getString(params, "sep", ",")
+	if sep == "" {
+		return nil, "", newError(codeInvalidParam, "sep must not be empty")
+	}
+
+	return lookupTyped(params, "list", "list", func(value string) (any, error) {
+		parts := strings.Split(value, sep)
+		items := make([]any, 0, len(parts))
+		for _, part := range parts {
+			if part = strings.TrimSpace(part); part != "" {
+				items = append(items, part)
+			}
+		}
+		return items, nil
+	})
+}
+
+func handleJSON(params map[string]any) (any, string, error) {
+	value, resultType, err := lookupTyped(params, "JSON value", "json", func(value string) (any, error) {
+		var v any
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	if err != nil || resultType == "null" {
+		return value, resultType, err
+	}
+	return value, jsonType(value), nil
+}
+
+func handleURL(params map[string]any) (any, string, error) {
+	requireAbsolute := getBool(params, "absolute", true)
+
+	return lookupTyped(params, "URL", "string", func(value string) (any, error) {
+		u, err := url.Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		if requireAbsolute && (u.Scheme == "" || u.Host == "") {
+			return nil, fmt.Errorf("expected an absolute URL with scheme and host")
+		}
+		return u.String(), nil
+	})
+}
+
+// lookupTyped reads params.key from the environment and converts it with
+// parse, describing the expected value as name in errors. Unset or empty
+// variables fall back to params.default (parsed the same way when it is a
+// string), fail when params.required is set, and are null otherwise. Parse
+// failures are reported as INVALID_PARAM naming the variable or default.
+func lookupTyped(params map[string]any, name, valueType string, parse func(string) (any, error)) (any, string, error) {
+	key := getString(params, "key", "")
+	if key == "" {
+		return nil, "", fmt.Errorf("key parameter required")
+	}
+
+	value, exists, err := lookupEnv(key)
+	if err != nil {
+		return nil, "", err
+	}
+	if !exists || value == "" {
+		if defaultValue, ok := params["default"]; ok {
+			text, isString := defaultValue.(string)
+			if !isString {
+				return defaultValue, valueType, nil
+			}
+			parsed, err := parse(strings.TrimSpace(text))
+			if err != nil {
+				return nil, "", newError(codeInvalidParam, "default %q for %s is not a valid %s: %v", text, key, name, err)
+			}
+			return parsed, valueType, nil
+		}
+		if getBool(params, "required", false) {
+			return nil, "", newError(codeNotFound, "environment variable %s is required", key)
+		}
+		return nil, "null", nil
+	}
+
+	parsed, err := parse(strings.TrimSpace(value))
+	if err != nil {
+		return nil, "", newError(codeInvalidParam, "environment variable %s is not a valid %s: %v", key, name, err)
+	}
+
+	return parsed, valueType, nil
+}
+
+// jsonType maps a decoded JSON value to its UP type name
+func jsonType(v any) string {
+	switch val := v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case float64:
+		if val == math.Trunc(val) && math.Abs(val) < 1<<53 {
+			return "int"
+		}
+		return "float"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "block"
+	default:
+		return "null"
+	}
+}
+
+func handleLoad(params map[string]any, context map[string]any) (any, string, error) {
+	path := getString(params, "path", "")
+	if path == "" {
+		return nil, "", fmt.Errorf("path parameter required")
+	}
+
+	precedence := getString(params, "precedence", getString(context, "env_precedence", precedenceProcess))
+	parsed, err := readDotenv(resolvePath(context, path), environ, precedence)
+	if err != nil {
+		return nil, "", err
+	}
+
+	layerDotenv(environ, parsed, precedence)
+
+	// Report the effective value of each variable the file defines
+	result := make(map[string]string, len(parsed))
+	for _, v := range parsed {
+		if allowed(v.key) {
+			result[v.key] = environ[v.key]
+		}
+	}
+
+	return result, "block", nil
+}
+
+// getStringList reads a parameter given either as a single string or a list
+func getStringList(params map[string]any, key string) []string {
+	switch v := params[key].(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []any:
+		var result []string
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
 func getString(params map[string]any, key, defaultValue string) string {
 	if v, ok := params[key]; ok {
 		if s, ok := v.(string); ok {
@@ -116,6 +429,15 @@ func getString(params map[string]any, key, defaultValue string) string {
 	return defaultValue
 }
 
+func getBool(params map[string]any, key string, defaultValue bool) bool {
+	if v, ok := params[key]; ok {
+		if b, ok := v.(bool); ok {
+			return b
+		}
+	}
+	return defaultValue
+}
+
 func sendResponse(value any, valueType string) {
 	resp := Response{
 		Value: value,
@@ -128,8 +450,13 @@ func sendResponse(value any, valueType string) {
 }
 
 func sendError(message string) {
+	sendErrorCode(message, "")
+}
+
+func sendErrorCode(message, code string) {
 	resp := Response{
 		Error: message,
+		Code:  code,
 	}
 	if err := json.NewEncoder(os.Stdout).Encode(resp); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to encode error response: %v\n", err)
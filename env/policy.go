@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// defaultPolicyFile is looked up next to the document being rendered when
+// context.policy_file is not set
+const defaultPolicyFile = ".up-env-policy.json"
+
+// envPolicy restricts which variables may be read. Patterns are globs
+// (* ? [...]) matched against variable names. Deny patterns always win; when
+// allow patterns are present a name must match one of them.
+type envPolicy struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// policies holds every policy in effect; a variable is readable only if all
+// of them allow it
+var policies []envPolicy
+
+// loadPolicies collects the policy from the policy file (context.policy_file,
+// or .up-env-policy.json beside the document) and the one inline in
+// context.policy.
+func loadPolicies(context map[string]any) ([]envPolicy, error) {
+	var result []envPolicy
+
+	policyFile := getString(context, "policy_file", "")
+	explicit := policyFile != ""
+	if !explicit {
+		if doc := getString(context, "file", ""); doc != "" {
+			policyFile = filepath.Join(filepath.Dir(doc), defaultPolicyFile)
+		}
+	}
+	if policyFile != "" {
+		data, err := os.ReadFile(resolvePath(context, policyFile))
+		switch {
+		case err == nil:
+			var p envPolicy
+			if err := json.Unmarshal(data, &p); err != nil {
+				return nil, fmt.Errorf("invalid policy file %s: %v", policyFile, err)
+			}
+			result = append(result, p)
+		case explicit || !errors.Is(err, os.ErrNotExist):
+			return nil, fmt.Errorf("failed to read policy file: %v", err)
+		}
+	}
+
+	if inline, ok := context["policy"].(map[string]any); ok {
+		result = append(result, envPolicy{
+			Allow: getStringList(inline, "allow"),
+			Deny:  getStringList(inline, "deny"),
+		})
+	}
+
+	for _, p := range result {
+		for _, pattern := range append(p.Allow, p.Deny...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid policy pattern %q: %v", pattern, err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (p envPolicy) allows(name string) bool {
+	for _, pattern := range p.Deny {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+	}
+	if len(p.Allow) == 0 {
+		return true
+	}
+	for _, pattern := range p.Allow {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether every policy in effect allows reading name
+func allowed(name string) bool {
+	for _, p := range policies {
+		if !p.allows(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupEnv reads a variable through the policy. Denied variables fail with
+// PERMISSION_DENIED whether or not they are set, so neither their value nor
+// their existence leaks.
+func lookupEnv(name string) (string, bool, error) {
+	if !allowed(name) {
+		return "", false, newError(codePermissionDenied, "access to environment variable %s is denied by policy", name)
+	}
+	value, ok := environ[name]
+	return value, ok, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"path"
+	"strings"
+)
+
+// Redaction modes
+const (
+	redactNone    = "none"
+	redactSecrets = "secrets"
+	redactAll     = "all"
+)
+
+// Redaction styles
+const (
+	styleMask = "mask"
+	styleHash = "hash"
+)
+
+const redactedMask = "****"
+
+// secretNamePatterns flag variables whose names suggest a secret. Names are
+// upper-cased before matching.
+var secretNamePatterns = []string{
+	"*TOKEN*", "*SECRET*", "*PASSWORD*", "*PASSWD*", "*PASSPHRASE*",
+	"*CREDENTIAL*", "*API_KEY*", "*APIKEY*", "*PRIVATE_KEY*", "*ACCESS_KEY*",
+	"*SESSION_KEY*", "*SIGNING_KEY*", "*ENCRYPTION_KEY*", "*_PWD",
+}
+
+// secretValuePrefixes are well-known credential formats
+var secretValuePrefixes = []string{
+	"ghp_", "gho_", "ghs_", "github_pat_", "glpat-", "sk-", "sk_live_",
+	"rk_live_", "xoxb-", "xoxp-", "AKIA", "ASIA", "-----BEGIN",
+}
+
+// redactor replaces values in output so secrets stay present but unrevealed
+type redactor struct {
+	mode  string
+	style string
+	key   []byte
+}
+
+// newRedactor reads params.redact and params.redact_style, falling back to
+// the same keys in the context. It returns nil when redaction is off.
+func newRedactor(params, context map[string]any) (*redactor, error) {
+	mode, err := redactMode(params["redact"])
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := params["redact"]; !ok {
+		if mode, err = redactMode(context["redact"]); err != nil {
+			return nil, err
+		}
+	}
+	if mode == redactNone {
+		return nil, nil
+	}
+
+	style := getString(params, "redact_style", getString(context, "redact_style", styleMask))
+	if style != styleMask && style != styleHash {
+		return nil, newError(codeInvalidParam, "redact_style must be %s or %s", styleMask, styleHash)
+	}
+
+	return &redactor{
+		mode:  mode,
+		style: style,
+		key:   []byte(getString(context, "redact_key", "")),
+	}, nil
+}
+
+// redactMode accepts true (secrets), false (none) or a mode name
+func redactMode(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return redactNone, nil
+	case bool:
+		if v {
+			return redactSecrets, nil
+		}
+		return redactNone, nil
+	case string:
+		switch v {
+		case redactNone, redactSecrets, redactAll:
+			return v, nil
+		}
+	}
+	return "", newError(codeInvalidParam, "redact must be true, false, %s, %s or %s", redactNone, redactSecrets, redactAll)
+}
+
+// apply returns value, or its replacement if it should be redacted. A nil
+// redactor returns value unchanged.
+func (r *redactor) apply(name, value string) string {
+	if r == nil || value == "" {
+		return value
+	}
+	if r.mode == redactSecrets && !looksSecret(name, value) {
+		return value
+	}
+	if r.style == styleMask {
+		return redactedMask
+	}
+	return r.fingerprint(name, value)
+}
+
+// fingerprint is a short stable hash of the variable, so a changed secret
+// shows up in diffs. With context.redact_key it is an HMAC, which stops
+// low-entropy secrets from being guessed from their fingerprint.
+func (r *redactor) fingerprint(name, value string) string {
+	var sum []byte
+	if len(r.key) > 0 {
+		mac := hmac.New(sha256.New, r.key)
+		mac.Write([]byte(name + "\x00" + value))
+		sum = mac.Sum(nil)
+	} else {
+		h := sha256.Sum256([]byte(name + "\x00" + value))
+		sum = h[:]
+	}
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// looksSecret guesses whether a variable holds a secret from its name, a
+// known credential prefix, or a value that looks like random key material.
+func looksSecret(name, value string) bool {
+	upper := strings.ToUpper(name)
+	for _, pattern := range secretNamePatterns {
+		if ok, _ := path.Match(pattern, upper); ok {
+			return true
+		}
+	}
+	for _, prefix := range secretValuePrefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return looksRandom(value)
+}
+
+// looksRandom reports whether value is a long run of token characters with
+// letters, digits and high Shannon entropy, as generated keys are.
+func looksRandom(value string) bool {
+	if len(value) < 20 {
+		return false
+	}
+
+	var letters, digits bool
+	counts := make(map[rune]int)
+	for _, c := range value {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+			letters = true
+		case c >= '0' && c <= '9':
+			digits = true
+		case strings.ContainsRune("+/=_-", c):
+		default:
+			return false
+		}
+		counts[c]++
+	}
+	if !letters || !digits {
+		return false
+	}
+
+	entropy := 0.0
+	n := float64(len(value))
+	for _, count := range counts {
+		p := float64(count) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy >= 3.5
+}
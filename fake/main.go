@@ -2,12 +2,45 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/jaswdr/faker"
 )
 
+// namespace is the name this plugin is installed under
+const namespace = "fake"
+
+// Error codes reported in the code field of error responses
+const (
+	codeInvalidParam  = "INVALID_PARAM"
+	codeLimitExceeded = "LIMIT_EXCEEDED"
+)
+
+// codedError is an error carrying a protocol error code
+type codedError struct {
+	code    string
+	message string
+}
+
+func (e *codedError) Error() string {
+	return e.message
+}
+
+func newError(code, format string, args ...any) error {
+	return &codedError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// errorCode returns the protocol code of err, or "" if it has none
+func errorCode(err error) string {
+	var ce *codedError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	return ""
+}
+
 type Request struct {
 	Function string         `json:"function"`
 	Params   map[string]any `json:"params"`
@@ -18,6 +51,7 @@ type Response struct {
 	Value any    `json:"value"`
 	Type  string `json:"type"`
 	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
 }
 
 var fake faker.Faker
@@ -42,94 +76,102 @@ func main() {
 	var resultType string
 	var err error
 
+	if getBool(req.Params, "unique", false) {
+		result, resultType, err = generateUnique(req, dispatch)
+	} else {
+		result, resultType, err = dispatch(req)
+	}
+
+	if err != nil {
+		sendErrorCode(err.Error(), errorCode(err))
+		return
+	}
+
+	sendResponse(result, resultType)
+}
+
+// dispatch routes a request to its handler
+func dispatch(req Request) (any, string, error) {
 	switch req.Function {
 	// Person functions
 	case "name":
-		result, resultType, err = handleName(req.Params)
+		return handleName(req.Params)
 	case "firstName":
-		result, resultType, err = handleFirstName(req.Params)
+		return handleFirstName(req.Params)
 	case "lastName":
-		result, resultType, err = handleLastName(req.Params)
+		return handleLastName(req.Params)
 	case "email":
-		result, resultType, err = handleEmail(req.Params)
+		return handleEmail(req.Params)
 	case "phone":
-		result, resultType, err = handlePhone(req.Params)
+		return handlePhone(req.Params)
 	case "username":
-		result, resultType, err = handleUsername(req.Params)
+		return handleUsername(req.Params)
 
 	// Internet functions
 	case "url":
-		result, resultType, err = handleURL(req.Params)
+		return handleURL(req.Params)
 	case "domain":
-		result, resultType, err = handleDomain(req.Params)
+		return handleDomain(req.Params)
 	case "ipv4":
-		result, resultType, err = handleIPv4(req.Params)
+		return handleIPv4(req.Params)
 	case "ipv6":
-		result, resultType, err = handleIPv6(req.Params)
+		return handleIPv6(req.Params)
 	case "userAgent":
-		result, resultType, err = handleUserAgent(req.Params)
+		return handleUserAgent(req.Params)
 
 	// Company functions
 	case "company":
-		result, resultType, err = handleCompany(req.Params)
+		return handleCompany(req.Params)
 	case "jobTitle":
-		result, resultType, err = handleJobTitle(req.Params)
+		return handleJobTitle(req.Params)
 
 	// Address functions
 	case "address":
-		result, resultType, err = handleAddress(req.Params)
+		return handleAddress(req.Params)
 	case "city":
-		result, resultType, err = handleCity(req.Params)
+		return handleCity(req.Params)
 	case "state":
-		result, resultType, err = handleState(req.Params)
+		return handleState(req.Params)
 	case "country":
-		result, resultType, err = handleCountry(req.Params)
+		return handleCountry(req.Params)
 	case "zipCode":
-		result, resultType, err = handleZipCode(req.Params)
+		return handleZipCode(req.Params)
 	case "latitude":
-		result, resultType, err = handleLatitude(req.Params)
+		return handleLatitude(req.Params)
 	case "longitude":
-		result, resultType, err = handleLongitude(req.Params)
+		return handleLongitude(req.Params)
 
 	// Text functions
 	case "word":
-		result, resultType, err = handleWord(req.Params)
+		return handleWord(req.Params)
 	case "sentence":
-		result, resultType, err = handleSentence(req.Params)
+		return handleSentence(req.Params)
 	case "paragraph":
-		result, resultType, err = handleParagraph(req.Params)
+		return handleParagraph(req.Params)
 	case "lorem":
-		result, resultType, err = handleLorem(req.Params)
+		return handleLorem(req.Params)
 
 	// Commerce functions
 	case "product":
-		result, resultType, err = handleProduct(req.Params)
+		return handleProduct(req.Params)
 	case "price":
-		result, resultType, err = handlePrice(req.Params)
+		return handlePrice(req.Params)
 	case "currency":
-		result, resultType, err = handleCurrency(req.Params)
+		return handleCurrency(req.Params)
 
 	// Color functions
 	case "color":
-		result, resultType, err = handleColor(req.Params)
+		return handleColor(req.Params)
 	case "hexColor":
-		result, resultType, err = handleHexColor(req.Params)
+		return handleHexColor(req.Params)
 
 	// Misc functions
 	case "creditCard":
-		result, resultType, err = handleCreditCard(req.Params)
+		return handleCreditCard(req.Params)
 
 	default:
-		sendError(fmt.Sprintf("Unknown function: %s", req.Function))
-		return
+		return nil, "", fmt.Errorf("Unknown function: %s", req.Function)
 	}
-
-	if err != nil {
-		sendError(err.Error())
-		return
-	}
-
-	sendResponse(result, resultType)
 }
 
 // Person functions
@@ -319,6 +361,15 @@ func getFloat(params map[string]any, key string, defaultValue float64) float64 {
 	return defaultValue
 }
 
+func getBool(params map[string]any, key string, defaultValue bool) bool {
+	if v, ok := params[key]; ok {
+		if b, ok := v.(bool); ok {
+			return b
+		}
+	}
+	return defaultValue
+}
+
 func sendResponse(value any, valueType string) {
 	resp := Response{
 		Value: value,
@@ -331,8 +382,13 @@ func sendResponse(value any, valueType string) {
 }
 
 func sendError(message string) {
+	sendErrorCode(message, "")
+}
+
+func sendErrorCode(message, code string) {
 	resp := Response{
 		Error: message,
+		Code:  code,
 	}
 	if err := json.NewEncoder(os.Stdout).Encode(resp); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to encode error response: %v\n", err)
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// maxCacheBytes bounds the total size of cached file contents in a session
+const maxCacheBytes = 64 << 20
+
+// readCache keeps whole-file reads on disk for the rest of a render, so the
+// separate plugin processes of one session share them. Entries are keyed by
+// absolute path, modification time and size, so an edited file misses the
+// cache. A nil cache disables caching.
+type readCache struct {
+	dir string
+}
+
+// cache is the read cache for the current request
+var cache *readCache
+
+// openCache returns the cache for context.session, or nil when there is no
+// session or params.cache is false. The cache lives in the temp directory,
+// beside the state files other namespaces keep per session.
+func openCache(params, context map[string]any) *readCache {
+	session := getString(context, "session", "")
+	if session == "" || !getBool(params, "cache", true) {
+		return nil
+	}
+	dir := filepath.Join(os.TempDir(), "up-ns-file-cache-"+filepath.Base(session))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil
+	}
+	return &readCache{dir: dir}
+}
+
+// entry returns the cache file for path as described by info
+func (c *readCache) entry(path string, info fs.FileInfo) (string, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	key := fmt.Sprintf("%s\x00%d\x00%d", abs, info.ModTime().UnixNano(), info.Size())
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])), true
+}
+
+func (c *readCache) get(path string, info fs.FileInfo) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	entry, ok := c.entry(path, info)
+	if !ok {
+		return nil, false
+	}
+	data, err := os.ReadFile(entry)
+	if err != nil || int64(len(data)) != info.Size() {
+		return nil, false
+	}
+	return data, true
+}
+
+// put stores data unless the session's cache would grow past maxCacheBytes.
+// Failures only cost a later cache miss, so they are ignored.
+func (c *readCache) put(path string, info fs.FileInfo, data []byte) {
+	if c == nil {
+		return
+	}
+	entry, ok := c.entry(path, info)
+	if !ok {
+		return
+	}
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	total := int64(len(data))
+	for _, e := range entries {
+		if i, err := e.Info(); err == nil {
+			total += i.Size()
+		}
+	}
+	if total > maxCacheBytes {
+		return
+	}
+
+	tmp, err := os.CreateTemp(c.dir, ".tmp*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil && closeErr == nil {
+		os.Rename(tmp.Name(), entry)
+	}
+}
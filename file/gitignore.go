@@ -0,0 +1,79 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// ignoreRule is one pattern from a .gitignore file
+type ignoreRule struct {
+	// base is the directory holding the .gitignore, relative to the listing
+	base    string
+	pattern string
+	negate  bool
+	dirOnly bool
+	// anchored patterns contain a slash and match relative to base;
+	// others match the name at any depth
+	anchored bool
+}
+
+// parseIgnore parses .gitignore syntax: comments, blank lines, ! negation,
+// trailing / for directories, leading or inner / for anchoring, and **.
+func parseIgnore(data, base string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" || validGlob(line) != nil {
+			continue
+		}
+
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// ignored applies rules in order, the last match deciding, as git does
+func ignored(rules []ignoreRule, rel string, isDir bool) bool {
+	result := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		target := rel
+		if rule.base != "" {
+			if !strings.HasPrefix(rel, rule.base+"/") {
+				continue
+			}
+			target = strings.TrimPrefix(rel, rule.base+"/")
+		}
+		if !rule.anchored {
+			target = path.Base(target)
+		}
+
+		if globMatch(rule.pattern, target) {
+			result = !rule.negate
+		}
+	}
+	return result
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// defaultMatchLimit caps how many matches grep and find return unless
+	// limit says otherwise
+	defaultMatchLimit = 1000
+	maxMatchLimit     = 100000
+)
+
+// grepMatch is a matching line, or a capture group within it
+type grepMatch struct {
+	line int
+	text string
+}
+
+// searcher finds regular expression matches line by line
+type searcher struct {
+	re *regexp.Regexp
+	// group is the capture group to return, or -1 for whole lines
+	group int
+	limit int
+	found int
+	// strict fails with LIMIT_EXCEEDED instead of stopping at limit
+	strict bool
+	// full is set once limit matches have been found without strict
+	full bool
+}
+
+// handleGrep returns the lines of a file matching an RE2 pattern, or one
+// capture group from each match
+func handleGrep(params map[string]any, context map[string]any) (any, string, error) {
+	path := getString(params, "path", "")
+	if path == "" {
+		return nil, "", fmt.Errorf("path parameter required")
+	}
+	path = resolvePath(context, path)
+
+	s, err := newSearcher(params)
+	if err != nil {
+		return nil, "", err
+	}
+	maxBytes, err := getMaxBytes(params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := readLimited(path, 0, -1, maxBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	if !utf8.Valid(data) {
+		return nil, "", newError(codeInvalidParam, "%s is not valid UTF-8", path)
+	}
+
+	matches, err := s.search(path, data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	result := make([]string, len(matches))
+	for i, m := range matches {
+		result[i] = m.text
+	}
+	return result, "list", nil
+}
+
+// handleFind searches the files under a directory, returning a block with
+// the path, line number and text of each match. Files that aren't UTF-8
+// text or are larger than max_bytes are skipped.
+func handleFind(params map[string]any, context map[string]any) (any, string, error) {
+	dir := resolvePath(context, getString(params, "dir", getString(params, "path", ".")))
+
+	s, err := newSearcher(params)
+	if err != nil {
+		return nil, "", err
+	}
+	maxBytes, err := getMaxBytes(params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	files := getString(params, "files", "*")
+	if err := validGlob(files); err != nil {
+		return nil, "", err
+	}
+	l := &lister{
+		dir:       dir,
+		pattern:   files,
+		fileType:  typeFile,
+		include:   getStringList(params, "include"),
+		exclude:   getStringList(params, "exclude"),
+		gitignore: getBool(params, "gitignore", false),
+		needInfo:  true,
+	}
+	for _, p := range append(l.include, l.exclude...) {
+		if err := validGlob(p); err != nil {
+			return nil, "", err
+		}
+	}
+
+	var rules []ignoreRule
+	if l.gitignore {
+		if rules, err = l.loadIgnore(nil, ""); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := l.walk("", 1, rules); err != nil {
+		return nil, "", err
+	}
+	sortEntries(l.entries, sortName)
+
+	fullPath := getBool(params, "full_path", false)
+	results := []any{}
+	for _, e := range l.entries {
+		if s.full {
+			break
+		}
+		if e.info.Size() > maxBytes {
+			continue
+		}
+		path := filepath.Join(dir, filepath.FromSlash(e.rel))
+		data, err := readLimited(path, 0, -1, maxBytes)
+		if err != nil {
+			return nil, "", err
+		}
+		// Skip binary files, as grep -I does
+		if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+			continue
+		}
+
+		matches, err := s.search(path, data)
+		if err != nil {
+			return nil, "", err
+		}
+
+		name := filepath.FromSlash(e.rel)
+		if fullPath {
+			name = path
+		}
+		for _, m := range matches {
+			results = append(results, map[string]any{
+				"path": name,
+				"line": m.line,
+				"text": m.text,
+			})
+		}
+	}
+
+	return results, "list", nil
+}
+
+// newSearcher compiles params.pattern and resolves params.group, an index
+// or the name of a (?P<name>...) group
+func newSearcher(params map[string]any) (*searcher, error) {
+	pattern := getString(params, "pattern", "")
+	if pattern == "" {
+		return nil, fmt.Errorf("pattern parameter required")
+	}
+	if getBool(params, "ignore_case", false) {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, newError(codeInvalidParam, "invalid pattern: %v", err)
+	}
+
+	s := &searcher{
+		re:     re,
+		group:  -1,
+		limit:  getInt(params, "limit", defaultMatchLimit),
+		strict: getBool(params, "strict_limit", false),
+	}
+	if s.limit < 1 || s.limit > maxMatchLimit {
+		return nil, newError(codeInvalidParam, "limit must be between 1 and %d", maxMatchLimit)
+	}
+
+	switch group := params["group"].(type) {
+	case nil:
+	case float64:
+		s.group = int(group)
+	case string:
+		if n, err := strconv.Atoi(group); err == nil {
+			s.group = n
+		} else if s.group = re.SubexpIndex(group); s.group < 0 {
+			return nil, newError(codeInvalidParam, "pattern has no group named %q", group)
+		}
+	default:
+		return nil, newError(codeInvalidParam, "group must be a group number or name")
+	}
+	if _, ok := params["group"]; ok && (s.group < 0 || s.group > re.NumSubexp()) {
+		return nil, newError(codeInvalidParam, "group must be between 0 and %d", re.NumSubexp())
+	}
+
+	return s, nil
+}
+
+// search returns the matches in data, stopping once s.limit have been found
+// across all searched files, or with strict_limit failing with
+// LIMIT_EXCEEDED if there are more
+func (s *searcher) search(path string, data []byte) ([]grepMatch, error) {
+	var matches []grepMatch
+	text := strings.TrimPrefix(string(data), string(bomUTF8))
+	if text == "" {
+		return nil, nil
+	}
+	text = strings.TrimSuffix(text, "\n")
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+
+		var found []string
+		if s.group < 0 {
+			if s.re.MatchString(line) {
+				found = []string{line}
+			}
+		} else {
+			for _, m := range s.re.FindAllStringSubmatchIndex(line, -1) {
+				// An optional group that didn't take part in the match
+				if m[2*s.group] >= 0 {
+					found = append(found, line[m[2*s.group]:m[2*s.group+1]])
+				}
+			}
+		}
+
+		for _, f := range found {
+			if s.found == s.limit {
+				return nil, newError(codeLimitExceeded, "%s: more than %d matches; raise limit or narrow the pattern", path, s.limit)
+			}
+			s.found++
+			matches = append(matches, grepMatch{line: i + 1, text: f})
+			// Without strict_limit there's no need to look for more
+			if s.found == s.limit && !s.strict {
+				s.full = true
+				return matches, nil
+			}
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxIncludeDepth bounds how deeply included documents may nest
+const maxIncludeDepth = 32
+
+// includer reads UP documents for include(), tracking the chain of files
+// being included to detect cycles
+type includer struct {
+	stack    []string
+	maxBytes int64
+}
+
+// handleInclude parses another UP document and returns it as a block.
+// Relative paths resolve against the including document: context.file for
+// the top-level call, the included file for nested $file.include calls.
+func handleInclude(params map[string]any, context map[string]any) (any, string, error) {
+	path := getString(params, "path", "")
+	if path == "" {
+		return nil, "", fmt.Errorf("path parameter required")
+	}
+
+	maxBytes, err := getMaxBytes(params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	in := &includer{maxBytes: maxBytes}
+	// Including the rendering document itself is a cycle too
+	if doc := getString(context, "file", ""); doc != "" {
+		if abs, err := filepath.Abs(doc); err == nil {
+			in.stack = append(in.stack, abs)
+		}
+	}
+
+	block, err := in.include(resolvePath(context, path))
+	if err != nil {
+		return nil, "", err
+	}
+	return block, "block", nil
+}
+
+func (in *includer) include(path string) (map[string]any, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range in.stack {
+		if p == abs {
+			chain := append(append([]string(nil), in.stack[i:]...), abs)
+			return nil, newError(codeInvalidParam, "include cycle: %s", strings.Join(chain, " -> "))
+		}
+	}
+	if len(in.stack) >= maxIncludeDepth {
+		return nil, newError(codeLimitExceeded, "%s: includes nested more than %d deep", path, maxIncludeDepth)
+	}
+
+	data, err := readLimited(abs, 0, -1, in.maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, bomUTF8)
+	if !utf8.Valid(data) {
+		return nil, newError(codeInvalidParam, "%s is not valid UTF-8", path)
+	}
+
+	in.stack = append(in.stack, abs)
+	defer func() { in.stack = in.stack[:len(in.stack)-1] }()
+
+	dir := filepath.Dir(abs)
+	return parseUP(path, string(data), func(namespace, function string, args map[string]string) (any, error) {
+		if namespace != "file" || function != "include" {
+			return nil, fmt.Errorf("$%s.%s can't be evaluated in an included document; only static values and $file.include are supported", namespace, function)
+		}
+		target := args["path"]
+		if target == "" {
+			target = args["0"]
+		}
+		if target == "" {
+			return nil, fmt.Errorf("$file.include needs a path")
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(dir, target)
+		}
+		return in.include(target)
+	})
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Entry types for list(type=...)
+const (
+	typeAny     = "any"
+	typeFile    = "file"
+	typeDir     = "dir"
+	typeSymlink = "symlink"
+)
+
+// Sort orders for list(sort=...)
+const (
+	sortName  = "name"
+	sortSize  = "size"
+	sortMtime = "mtime"
+)
+
+// listEntry is a matched directory entry with its path relative to the
+// listed directory (slash-separated)
+type listEntry struct {
+	rel   string
+	entry fs.DirEntry
+	info  fs.FileInfo
+}
+
+// lister walks a directory tree collecting entries that pass every filter
+type lister struct {
+	dir      string
+	pattern  string
+	fileType string
+	include  []string
+	exclude  []string
+	maxDepth int
+	// gitignore applies .gitignore files found while walking
+	gitignore bool
+	needInfo  bool
+	entries   []listEntry
+}
+
+func handleList(params map[string]any, context map[string]any) (any, string, error) {
+	dir := resolvePath(context, getString(params, "dir", getString(params, "path", ".")))
+	pattern := getString(params, "pattern", "*")
+	if err := validGlob(pattern); err != nil {
+		return nil, "", err
+	}
+
+	l := &lister{
+		dir:       dir,
+		pattern:   pattern,
+		fileType:  getString(params, "type", typeAny),
+		include:   getStringList(params, "include"),
+		exclude:   getStringList(params, "exclude"),
+		gitignore: getBool(params, "gitignore", false),
+	}
+	switch l.fileType {
+	case typeAny, typeFile, typeDir, typeSymlink:
+	default:
+		return nil, "", newError(codeInvalidParam, "type must be %s, %s, %s or %s", typeAny, typeFile, typeDir, typeSymlink)
+	}
+	for _, p := range append(l.include, l.exclude...) {
+		if err := validGlob(p); err != nil {
+			return nil, "", err
+		}
+	}
+
+	// Simple patterns list as deep as they have segments, so "*.json" keeps
+	// listing only immediate children; "**" walks the whole tree
+	l.maxDepth = strings.Count(pattern, "/") + 1
+	if strings.Contains(pattern, "**") {
+		l.maxDepth = 0
+	}
+	if _, ok := params["max_depth"]; ok {
+		l.maxDepth = getInt(params, "max_depth", 0)
+		if l.maxDepth < 0 {
+			return nil, "", newError(codeInvalidParam, "max_depth must not be negative")
+		}
+	}
+
+	order := getString(params, "sort", sortName)
+	switch order {
+	case sortName:
+	case sortSize, sortMtime:
+		l.needInfo = true
+	default:
+		return nil, "", newError(codeInvalidParam, "sort must be %s, %s or %s", sortName, sortSize, sortMtime)
+	}
+
+	var rules []ignoreRule
+	if l.gitignore {
+		var err error
+		if rules, err = l.loadIgnore(nil, ""); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := l.walk("", 1, rules); err != nil {
+		return nil, "", err
+	}
+
+	sortEntries(l.entries, order)
+	if getBool(params, "reverse", false) {
+		for i, j := 0, len(l.entries)-1; i < j; i, j = i+1, j-1 {
+			l.entries[i], l.entries[j] = l.entries[j], l.entries[i]
+		}
+	}
+
+	fullPath := getBool(params, "full_path", false)
+	result := make([]string, 0, len(l.entries))
+	for _, e := range l.entries {
+		p := filepath.FromSlash(e.rel)
+		if fullPath {
+			p = filepath.Join(dir, p)
+		}
+		result = append(result, p)
+	}
+
+	return result, "list", nil
+}
+
+// walk lists the directory at rel (relative to l.dir) at the given depth
+func (l *lister) walk(rel string, depth int, rules []ignoreRule) error {
+	entries, err := box.ReadDir(filepath.Join(l.dir, filepath.FromSlash(rel)))
+	if err != nil {
+		return fileError("failed to read directory", err)
+	}
+
+	for _, entry := range entries {
+		childRel := path.Join(rel, entry.Name())
+		isDir := entry.IsDir()
+
+		if l.gitignore && (entry.Name() == ".git" || ignored(rules, childRel, isDir)) {
+			continue
+		}
+		if matchAny(l.exclude, childRel) {
+			continue
+		}
+
+		if l.matches(childRel, entry) {
+			e := listEntry{rel: childRel, entry: entry}
+			if l.needInfo {
+				if e.info, err = entry.Info(); err != nil {
+					return fmt.Errorf("failed to stat %s: %v", childRel, err)
+				}
+			}
+			l.entries = append(l.entries, e)
+		}
+
+		// Symlinked directories are listed but not followed
+		if isDir && (l.maxDepth == 0 || depth < l.maxDepth) {
+			childRules := rules
+			if l.gitignore {
+				if childRules, err = l.loadIgnore(rules, childRel); err != nil {
+					return err
+				}
+			}
+			if err := l.walk(childRel, depth+1, childRules); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matches applies the pattern, include list and type filter to an entry
+func (l *lister) matches(rel string, entry fs.DirEntry) bool {
+	// Patterns without a slash match the name at any depth
+	target := rel
+	if !strings.Contains(l.pattern, "/") {
+		target = path.Base(rel)
+	}
+	if !globMatch(l.pattern, target) {
+		return false
+	}
+	if len(l.include) > 0 && !matchAny(l.include, rel) {
+		return false
+	}
+
+	switch l.fileType {
+	case typeFile:
+		return entry.Type().IsRegular()
+	case typeDir:
+		return entry.IsDir()
+	case typeSymlink:
+		return entry.Type()&fs.ModeSymlink != 0
+	}
+	return true
+}
+
+// loadIgnore adds the rules of the .gitignore in directory rel, if any
+func (l *lister) loadIgnore(rules []ignoreRule, rel string) ([]ignoreRule, error) {
+	data, err := box.ReadFile(filepath.Join(l.dir, filepath.FromSlash(rel), ".gitignore"))
+	if err != nil {
+		if errorCode(err) != "" {
+			return nil, err
+		}
+		return rules, nil
+	}
+	// Copy so sibling directories don't see each other's rules
+	return append(append([]ignoreRule(nil), rules...), parseIgnore(string(data), rel)...), nil
+}
+
+// matchAny reports whether rel matches any of the patterns. Patterns
+// without a slash match the name at any depth.
+func matchAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		target := rel
+		if !strings.Contains(p, "/") {
+			target = path.Base(rel)
+		}
+		if globMatch(p, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortEntries(entries []listEntry, order string) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		switch order {
+		case sortSize:
+			if a.info.Size() != b.info.Size() {
+				return a.info.Size() < b.info.Size()
+			}
+		case sortMtime:
+			if !a.info.ModTime().Equal(b.info.ModTime()) {
+				return a.info.ModTime().Before(b.info.ModTime())
+			}
+		}
+		return a.rel < b.rel
+	})
+}
+
+// globMatch matches a slash-separated name against a glob in which "**"
+// stands for any number of path segments, including none
+func globMatch(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func validGlob(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return newError(codeInvalidParam, "invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
@@ -2,12 +2,43 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 )
 
+// Error codes reported in the code field of error responses
+const (
+	codeInvalidParam     = "INVALID_PARAM"
+	codeLimitExceeded    = "LIMIT_EXCEEDED"
+	codeNotFound         = "NOT_FOUND"
+	codePermissionDenied = "PERMISSION_DENIED"
+)
+
+// codedError is an error carrying a protocol error code
+type codedError struct {
+	code    string
+	message string
+}
+
+func (e *codedError) Error() string {
+	return e.message
+}
+
+func newError(code, format string, args ...any) error {
+	return &codedError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// errorCode returns the protocol code of err, or "" if it has none
+func errorCode(err error) string {
+	var ce *codedError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	return ""
+}
+
 type Request struct {
 	Function string         `json:"function"`
 	Params   map[string]any `json:"params"`
@@ -18,6 +49,7 @@ type Response struct {
 	Value any    `json:"value"`
 	Type  string `json:"type"`
 	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
 }
 
 func main() {
@@ -27,17 +59,55 @@ func main() {
 		return
 	}
 
+	var err error
+	box, err = openSandbox(req.Context)
+	if err != nil {
+		sendError(err.Error())
+		return
+	}
+
+	cache = openCache(req.Params, req.Context)
+
 	var result any
 	var resultType string
-	var err error
 
 	switch req.Function {
 	case "read":
-		result, resultType, err = handleRead(req.Params)
+		result, resultType, err = handleRead(req.Params, req.Context)
+	case "lines":
+		result, resultType, err = handleLines(req.Params, req.Context)
+	case "json":
+		result, resultType, err = handleJSON(req.Params, req.Context)
+	case "yaml":
+		result, resultType, err = handleYAML(req.Params, req.Context)
+	case "toml":
+		result, resultType, err = handleTOML(req.Params, req.Context)
+	case "csv":
+		result, resultType, err = handleCSV(req.Params, req.Context)
+	case "ini":
+		result, resultType, err = handleINI(req.Params, req.Context)
+	case "include":
+		result, resultType, err = handleInclude(req.Params, req.Context)
+	case "stat":
+		result, resultType, err = handleStat(req.Params, req.Context)
+	case "size":
+		result, resultType, err = handleSize(req.Params, req.Context)
+	case "hash":
+		result, resultType, err = handleHash(req.Params, req.Context)
 	case "exists":
-		result, resultType, err = handleExists(req.Params)
+		result, resultType, err = handleExists(req.Params, req.Context)
+	case "write":
+		result, resultType, err = handleWrite(req.Params, req.Context)
+	case "append":
+		result, resultType, err = handleAppend(req.Params, req.Context)
+	case "mkdir":
+		result, resultType, err = handleMkdir(req.Params, req.Context)
 	case "list":
-		result, resultType, err = handleList(req.Params)
+		result, resultType, err = handleList(req.Params, req.Context)
+	case "grep":
+		result, resultType, err = handleGrep(req.Params, req.Context)
+	case "find":
+		result, resultType, err = handleFind(req.Params, req.Context)
 	case "basename":
 		result, resultType, err = handleBasename(req.Params)
 	case "dirname":
@@ -46,70 +116,56 @@ func main() {
 		result, resultType, err = handleExt(req.Params)
 	case "join":
 		result, resultType, err = handleJoin(req.Params)
+	case "abs":
+		result, resultType, err = handleAbs(req.Params)
+	case "rel":
+		result, resultType, err = handleRel(req.Params)
+	case "clean":
+		result, resultType, err = handleClean(req.Params)
+	case "split":
+		result, resultType, err = handleSplit(req.Params)
+	case "stem":
+		result, resultType, err = handleStem(req.Params)
+	case "match":
+		result, resultType, err = handleMatch(req.Params)
 	default:
 		sendError(fmt.Sprintf("Unknown function: %s", req.Function))
 		return
 	}
 
 	if err != nil {
-		sendError(err.Error())
+		sendErrorCode(err.Error(), errorCode(err))
 		return
 	}
 
 	sendResponse(result, resultType)
 }
 
-func handleRead(params map[string]any) (any, string, error) {
+func handleExists(params map[string]any, context map[string]any) (any, string, error) {
 	path := getString(params, "path", "")
 	if path == "" {
 		return nil, "", fmt.Errorf("path parameter required")
 	}
+	path = resolvePath(context, path)
 
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to read file: %v", err)
-	}
-
-	return string(data), "string", nil
-}
-
-func handleExists(params map[string]any) (any, string, error) {
-	path := getString(params, "path", "")
-	if path == "" {
-		return nil, "", fmt.Errorf("path parameter required")
+	_, err := box.Stat(path)
+	if errorCode(err) == codePermissionDenied {
+		return nil, "", err
 	}
-
-	_, err := os.Stat(path)
 	return err == nil, "bool", nil
 }
 
-func handleList(params map[string]any) (any, string, error) {
-	dir := getString(params, "dir", ".")
-	pattern := getString(params, "pattern", "*")
-
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to read directory: %v", err)
-	}
-
-	var result []string
-	for _, entry := range entries {
-		name := entry.Name()
-		if matched, _ := filepath.Match(pattern, name); matched {
-			result = append(result, name)
-		}
-	}
-
-	return result, "list", nil
-}
-
 func handleBasename(params map[string]any) (any, string, error) {
 	path := getString(params, "path", "")
 	if path == "" {
 		return nil, "", fmt.Errorf("path parameter required")
 	}
+	style, err := getPathStyle(params)
+	if err != nil {
+		return nil, "", err
+	}
 
-	return filepath.Base(path), "string", nil
+	return style.Base(path), "string", nil
 }
 
 func handleDirname(params map[string]any) (any, string, error) {
@@ -117,8 +173,12 @@ func handleDirname(params map[string]any) (any, string, error) {
 	if path == "" {
 		return nil, "", fmt.Errorf("path parameter required")
 	}
+	style, err := getPathStyle(params)
+	if err != nil {
+		return nil, "", err
+	}
 
-	return filepath.Dir(path), "string", nil
+	return style.Dir(path), "string", nil
 }
 
 func handleExt(params map[string]any) (any, string, error) {
@@ -127,7 +187,12 @@ func handleExt(params map[string]any) (any, string, error) {
 		return nil, "", fmt.Errorf("path parameter required")
 	}
 
-	ext := filepath.Ext(path)
+	style, err := getPathStyle(params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ext := style.Ext(path)
 	// Remove leading dot
 	ext = strings.TrimPrefix(ext, ".")
 
@@ -140,12 +205,44 @@ func handleJoin(params map[string]any) (any, string, error) {
 		return nil, "", fmt.Errorf("parts parameter required and must be non-empty list")
 	}
 
+	style, err := getPathStyle(params)
+	if err != nil {
+		return nil, "", err
+	}
+
 	strParts := make([]string, len(parts))
 	for i, p := range parts {
 		strParts[i] = fmt.Sprint(p)
 	}
 
-	return filepath.Join(strParts...), "string", nil
+	return style.Join(strParts...), "string", nil
+}
+
+// fileError describes a failed file operation, keeping the code of sandbox
+// errors
+func fileError(action string, err error) error {
+	if code := errorCode(err); code != "" {
+		return err
+	}
+	return fmt.Errorf("%s: %v", action, err)
+}
+
+func getStringList(params map[string]any, key string) []string {
+	switch v := params[key].(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []any:
+		var result []string
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
 }
 
 func getString(params map[string]any, key, defaultValue string) string {
@@ -157,6 +254,41 @@ func getString(params map[string]any, key, defaultValue string) string {
 	return defaultValue
 }
 
+func getInt(params map[string]any, key string, defaultValue int) int {
+	if v, ok := params[key]; ok {
+		switch val := v.(type) {
+		case float64:
+			return int(val)
+		case int:
+			return val
+		}
+	}
+	return defaultValue
+}
+
+func getInt64(params map[string]any, key string, defaultValue int64) int64 {
+	if v, ok := params[key]; ok {
+		switch val := v.(type) {
+		case float64:
+			return int64(val)
+		case int64:
+			return val
+		case int:
+			return int64(val)
+		}
+	}
+	return defaultValue
+}
+
+func getBool(params map[string]any, key string, defaultValue bool) bool {
+	if v, ok := params[key]; ok {
+		if b, ok := v.(bool); ok {
+			return b
+		}
+	}
+	return defaultValue
+}
+
 func sendResponse(value any, valueType string) {
 	resp := Response{
 		Value: value,
@@ -169,8 +301,13 @@ func sendResponse(value any, valueType string) {
 }
 
 func sendError(message string) {
+	sendErrorCode(message, "")
+}
+
+func sendErrorCode(message, code string) {
 	resp := Response{
 		Error: message,
+		Code:  code,
 	}
 	if err := json.NewEncoder(os.Stdout).Encode(resp); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to encode error response: %v\n", err)
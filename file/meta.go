@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Hash algorithms for hash()
+const (
+	algoSHA256  = "sha256"
+	algoSHA512  = "sha512"
+	algoBLAKE2b = "blake2b"
+	algoMD5     = "md5"
+)
+
+// handleStat describes a file. Size, mode and time follow symlinks unless
+// follow=false; isSymlink always reports the path itself.
+func handleStat(params map[string]any, context map[string]any) (any, string, error) {
+	path := getString(params, "path", "")
+	if path == "" {
+		return nil, "", fmt.Errorf("path parameter required")
+	}
+	path = resolvePath(context, path)
+
+	linfo, err := box.Lstat(path)
+	if err != nil {
+		return nil, "", fileError("failed to stat file", err)
+	}
+
+	info := linfo
+	isSymlink := linfo.Mode()&fs.ModeSymlink != 0
+	if isSymlink && getBool(params, "follow", true) {
+		// A dangling symlink is described by the link itself
+		if target, err := box.Stat(path); err == nil {
+			info = target
+		} else if errorCode(err) != "" {
+			return nil, "", err
+		}
+	}
+
+	return map[string]any{
+		"name":      info.Name(),
+		"size":      info.Size(),
+		"mode":      fmt.Sprintf("%04o", info.Mode().Perm()),
+		"type":      fileType(info.Mode()),
+		"modTime":   info.ModTime().UTC().Format(time.RFC3339Nano),
+		"isDir":     info.IsDir(),
+		"isSymlink": isSymlink,
+	}, "block", nil
+}
+
+func handleSize(params map[string]any, context map[string]any) (any, string, error) {
+	path := getString(params, "path", "")
+	if path == "" {
+		return nil, "", fmt.Errorf("path parameter required")
+	}
+	path = resolvePath(context, path)
+
+	info, err := box.Stat(path)
+	if err != nil {
+		return nil, "", fileError("failed to stat file", err)
+	}
+
+	return info.Size(), "int", nil
+}
+
+// handleHash streams a file through the chosen digest
+func handleHash(params map[string]any, context map[string]any) (any, string, error) {
+	path := getString(params, "path", "")
+	if path == "" {
+		return nil, "", fmt.Errorf("path parameter required")
+	}
+	path = resolvePath(context, path)
+
+	algo := getString(params, "algo", algoSHA256)
+	var h hash.Hash
+	switch algo {
+	case algoSHA256:
+		h = sha256.New()
+	case algoSHA512:
+		h = sha512.New()
+	case algoBLAKE2b:
+		// BLAKE2b-512, as computed by b2sum
+		h, _ = blake2b.New512(nil)
+	case algoMD5:
+		h = md5.New()
+	default:
+		return nil, "", newError(codeInvalidParam, "algo must be %s, %s, %s or %s", algoSHA256, algoSHA512, algoBLAKE2b, algoMD5)
+	}
+
+	encoding := getString(params, "encoding", "hex")
+	if encoding != "hex" && encoding != "base64" {
+		return nil, "", newError(codeInvalidParam, "encoding must be hex or base64")
+	}
+
+	f, err := box.Open(path)
+	if err != nil {
+		return nil, "", fileError("failed to read file", err)
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		return nil, "", newError(codeInvalidParam, "%s is a directory", path)
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, "", fmt.Errorf("failed to read file: %v", err)
+	}
+
+	sum := h.Sum(nil)
+	if encoding == "base64" {
+		return base64.StdEncoding.EncodeToString(sum), "string", nil
+	}
+	return hex.EncodeToString(sum), "string", nil
+}
+
+// fileType names the kind of file a mode describes
+func fileType(mode fs.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "file"
+	case mode.IsDir():
+		return "dir"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+)
+
+// Path styles for the path functions
+const (
+	styleNative  = "native"
+	stylePOSIX   = "posix"
+	styleWindows = "windows"
+)
+
+// pathStyle implements lexical path operations for POSIX or Windows paths
+// independently of the host, so templates can generate Windows paths on
+// Linux and vice versa. Windows paths accept both separators, and may start
+// with a drive (C:) or UNC (\\server\share) volume.
+type pathStyle struct {
+	windows bool
+}
+
+// getPathStyle reads params.style: native (the host's style, the default),
+// posix or windows
+func getPathStyle(params map[string]any) (pathStyle, error) {
+	switch style := getString(params, "style", styleNative); style {
+	case styleNative:
+		return pathStyle{windows: runtime.GOOS == "windows"}, nil
+	case stylePOSIX:
+		return pathStyle{}, nil
+	case styleWindows:
+		return pathStyle{windows: true}, nil
+	default:
+		return pathStyle{}, newError(codeInvalidParam, "style must be %s, %s or %s", styleNative, stylePOSIX, styleWindows)
+	}
+}
+
+func (s pathStyle) separator() string {
+	if s.windows {
+		return `\`
+	}
+	return "/"
+}
+
+func (s pathStyle) isSeparator(c byte) bool {
+	return c == '/' || (s.windows && c == '\\')
+}
+
+// volume returns the leading drive or UNC volume of a Windows path
+func (s pathStyle) volume(p string) string {
+	if !s.windows || len(p) < 2 {
+		return ""
+	}
+	if p[1] == ':' && ('a' <= p[0] && p[0] <= 'z' || 'A' <= p[0] && p[0] <= 'Z') {
+		return p[:2]
+	}
+	if s.isSeparator(p[0]) && s.isSeparator(p[1]) {
+		// \\server\share
+		server := strings.IndexAny(p[2:], `\/`)
+		if server <= 0 {
+			return ""
+		}
+		rest := p[2+server+1:]
+		share := strings.IndexAny(rest, `\/`)
+		if share < 0 {
+			share = len(rest)
+		}
+		if share == 0 {
+			return ""
+		}
+		return p[:2+server+1+share]
+	}
+	return ""
+}
+
+// slash converts a volume-less path to forward slashes
+func (s pathStyle) slash(p string) string {
+	if s.windows {
+		return strings.ReplaceAll(p, `\`, "/")
+	}
+	return p
+}
+
+// native converts a forward-slash path to the style's separator
+func (s pathStyle) native(p string) string {
+	if s.windows {
+		return strings.ReplaceAll(p, "/", `\`)
+	}
+	return p
+}
+
+func (s pathStyle) Clean(p string) string {
+	vol := s.volume(p)
+	rest := p[len(vol):]
+	if rest == "" && vol != "" {
+		if s.isSeparator(vol[0]) {
+			return s.native(vol) + `\`
+		}
+		return vol + "."
+	}
+	return s.native(vol + path.Clean(s.slash(rest)))
+}
+
+func (s pathStyle) Join(parts ...string) string {
+	var nonEmpty []string
+	for _, p := range parts {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return ""
+	}
+	joined := strings.Join(nonEmpty, s.separator())
+	// C: joined with a relative path stays drive-relative: C:a, not C:\a
+	if vol := s.volume(nonEmpty[0]); vol != "" && vol == nonEmpty[0] && !s.isSeparator(vol[0]) && len(nonEmpty) > 1 {
+		joined = vol + strings.Join(nonEmpty[1:], s.separator())
+	}
+	return s.Clean(joined)
+}
+
+func (s pathStyle) IsAbs(p string) bool {
+	if !s.windows {
+		return strings.HasPrefix(p, "/")
+	}
+	vol := s.volume(p)
+	if vol == "" {
+		return false
+	}
+	if s.isSeparator(vol[0]) {
+		return true
+	}
+	rest := p[len(vol):]
+	return rest != "" && s.isSeparator(rest[0])
+}
+
+// Split splits after the final separator, as filepath.Split does
+func (s pathStyle) Split(p string) (dir, file string) {
+	vol := s.volume(p)
+	i := len(p) - 1
+	for i >= len(vol) && !s.isSeparator(p[i]) {
+		i--
+	}
+	return p[:i+1], p[i+1:]
+}
+
+func (s pathStyle) Base(p string) string {
+	if p == "" {
+		return "."
+	}
+	p = p[len(s.volume(p)):]
+	for len(p) > 0 && s.isSeparator(p[len(p)-1]) {
+		p = p[:len(p)-1]
+	}
+	if p == "" {
+		return s.separator()
+	}
+	_, file := s.Split(p)
+	return file
+}
+
+func (s pathStyle) Dir(p string) string {
+	vol := s.volume(p)
+	dir, _ := s.Split(p)
+	rest := dir[len(vol):]
+	if rest == "" {
+		return vol + "."
+	}
+	return s.native(vol + path.Clean(s.slash(rest)))
+}
+
+func (s pathStyle) Ext(p string) string {
+	for i := len(p) - 1; i >= 0 && !s.isSeparator(p[i]); i-- {
+		if p[i] == '.' {
+			return p[i:]
+		}
+	}
+	return ""
+}
+
+// Rel returns target relative to base, like filepath.Rel. Windows paths
+// compare case-insensitively.
+func (s pathStyle) Rel(base, target string) (string, error) {
+	baseVol, targetVol := s.volume(base), s.volume(target)
+	cleanBase := s.slash(s.Clean(base)[len(baseVol):])
+	cleanTarget := s.slash(s.Clean(target)[len(targetVol):])
+
+	if !s.equal(baseVol, targetVol) || strings.HasPrefix(cleanBase, "/") != strings.HasPrefix(cleanTarget, "/") {
+		return "", newError(codeInvalidParam, "can't make %s relative to %s", target, base)
+	}
+	if s.equal(cleanBase, cleanTarget) {
+		return ".", nil
+	}
+	if cleanBase == "." {
+		return s.native(cleanTarget), nil
+	}
+
+	baseParts := strings.Split(strings.TrimPrefix(cleanBase, "/"), "/")
+	targetParts := strings.Split(strings.TrimPrefix(cleanTarget, "/"), "/")
+	if cleanBase == "/" {
+		baseParts = nil
+	}
+	common := 0
+	for common < len(baseParts) && common < len(targetParts) && s.equal(baseParts[common], targetParts[common]) {
+		common++
+	}
+	for _, part := range baseParts[common:] {
+		if part == ".." {
+			return "", newError(codeInvalidParam, "can't make %s relative to %s", target, base)
+		}
+	}
+
+	parts := make([]string, 0, len(baseParts)-common+len(targetParts)-common)
+	for range baseParts[common:] {
+		parts = append(parts, "..")
+	}
+	parts = append(parts, targetParts[common:]...)
+	return strings.Join(parts, s.separator()), nil
+}
+
+func (s pathStyle) equal(a, b string) bool {
+	if s.windows {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// Match reports whether p matches a glob pattern, with ** matching any
+// number of directories. Windows paths match case-insensitively.
+func (s pathStyle) Match(pattern, p string) bool {
+	if s.windows {
+		pattern = strings.ToLower(strings.ReplaceAll(pattern, `\`, "/"))
+		p = strings.ToLower(s.slash(p))
+	}
+	return globMatch(pattern, p)
+}
+
+func handleAbs(params map[string]any) (any, string, error) {
+	p := getString(params, "path", "")
+	if p == "" {
+		return nil, "", fmt.Errorf("path parameter required")
+	}
+	style, err := getPathStyle(params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if style.IsAbs(p) {
+		return style.Clean(p), "string", nil
+	}
+
+	base := getString(params, "base", "")
+	switch {
+	case base != "":
+		if !style.IsAbs(base) {
+			return nil, "", newError(codeInvalidParam, "base must be an absolute path")
+		}
+	case getString(params, "style", styleNative) == styleNative:
+		if base, err = os.Getwd(); err != nil {
+			return nil, "", err
+		}
+	default:
+		return nil, "", newError(codeInvalidParam, "abs with an explicit style needs an absolute base")
+	}
+
+	return style.Join(base, p), "string", nil
+}
+
+func handleRel(params map[string]any) (any, string, error) {
+	base := getString(params, "base", "")
+	target := getString(params, "target", "")
+	if base == "" || target == "" {
+		return nil, "", fmt.Errorf("base and target parameters required")
+	}
+	style, err := getPathStyle(params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rel, err := style.Rel(base, target)
+	if err != nil {
+		return nil, "", err
+	}
+	return rel, "string", nil
+}
+
+func handleClean(params map[string]any) (any, string, error) {
+	p := getString(params, "path", "")
+	if p == "" {
+		return nil, "", fmt.Errorf("path parameter required")
+	}
+	style, err := getPathStyle(params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return style.Clean(p), "string", nil
+}
+
+func handleSplit(params map[string]any) (any, string, error) {
+	p := getString(params, "path", "")
+	if p == "" {
+		return nil, "", fmt.Errorf("path parameter required")
+	}
+	style, err := getPathStyle(params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	dir, file := style.Split(p)
+	return map[string]any{"dir": dir, "file": file}, "block", nil
+}
+
+func handleStem(params map[string]any) (any, string, error) {
+	p := getString(params, "path", "")
+	if p == "" {
+		return nil, "", fmt.Errorf("path parameter required")
+	}
+	style, err := getPathStyle(params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	base := style.Base(p)
+	return strings.TrimSuffix(base, style.Ext(base)), "string", nil
+}
+
+func handleMatch(params map[string]any) (any, string, error) {
+	pattern := getString(params, "pattern", "")
+	p := getString(params, "path", "")
+	if pattern == "" || p == "" {
+		return nil, "", fmt.Errorf("pattern and path parameters required")
+	}
+	style, err := getPathStyle(params)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := validGlob(style.slash(pattern)); err != nil {
+		return nil, "", err
+	}
+
+	return style.Match(pattern, p), "bool", nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	// defaultMaxBytes caps how much of a file one call returns unless
+	// max_bytes says otherwise
+	defaultMaxBytes = 16 << 20
+	maxMaxBytes     = 1 << 30
+)
+
+// Text encodings for read()
+const (
+	encodingUTF8    = "utf-8"
+	encodingUTF16   = "utf-16"
+	encodingUTF16LE = "utf-16le"
+	encodingUTF16BE = "utf-16be"
+	encodingLatin1  = "latin1"
+	encodingBase64  = "base64"
+)
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+func handleRead(params map[string]any, context map[string]any) (any, string, error) {
+	path := getString(params, "path", "")
+	if path == "" {
+		return nil, "", fmt.Errorf("path parameter required")
+	}
+	path = resolvePath(context, path)
+
+	encoding := strings.ToLower(getString(params, "encoding", encodingUTF8))
+	switch encoding {
+	case "utf8":
+		encoding = encodingUTF8
+	case encodingUTF8, encodingUTF16, encodingUTF16LE, encodingUTF16BE, encodingLatin1, encodingBase64:
+	default:
+		return nil, "", newError(codeInvalidParam, "encoding must be utf-8, utf-16, utf-16le, utf-16be, latin1 or base64")
+	}
+
+	maxBytes, err := getMaxBytes(params)
+	if err != nil {
+		return nil, "", err
+	}
+	offset := getInt64(params, "offset", 0)
+	if offset < 0 {
+		return nil, "", newError(codeInvalidParam, "offset must not be negative")
+	}
+	length := getInt64(params, "length", -1)
+	if _, ok := params["length"]; ok && length < 0 {
+		return nil, "", newError(codeInvalidParam, "length must not be negative")
+	}
+
+	data, err := readLimited(path, offset, length, maxBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// A byte order mark can only appear at the start of the file
+	text, err := decodeText(data, encoding, offset == 0 && getBool(params, "strip_bom", true))
+	if err != nil {
+		return nil, "", newError(codeInvalidParam, "%s: %v", path, err)
+	}
+
+	return text, "string", nil
+}
+
+// handleLines returns lines start through end (1-based, inclusive) of a
+// UTF-8 text file, reading only as far as needed
+func handleLines(params map[string]any, context map[string]any) (any, string, error) {
+	path := getString(params, "path", "")
+	if path == "" {
+		return nil, "", fmt.Errorf("path parameter required")
+	}
+	path = resolvePath(context, path)
+
+	start := getInt64(params, "start", 1)
+	end := getInt64(params, "end", 0)
+	if start < 1 {
+		return nil, "", newError(codeInvalidParam, "start must be at least 1")
+	}
+	if end != 0 && end < start {
+		return nil, "", newError(codeInvalidParam, "end must be 0 (end of file) or at least start")
+	}
+	maxBytes, err := getMaxBytes(params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	f, err := box.Open(path)
+	if err != nil {
+		return nil, "", fileError("failed to read file", err)
+	}
+	defer f.Close()
+
+	// Every byte read counts against max_bytes, including the lines skipped
+	// before start, so a huge file or line fails before it is buffered
+	lines := []any{}
+	total := int64(0)
+	r := bufio.NewReader(f)
+	for n := int64(1); end == 0 || n <= end; n++ {
+		var line []byte
+		size := 0
+		eof := false
+		for {
+			chunk, err := r.ReadSlice('\n')
+			size += len(chunk)
+			total += int64(len(chunk))
+			if total > maxBytes {
+				return nil, "", newError(codeLimitExceeded, "%s: lines exceed max_bytes (%d)", path, maxBytes)
+			}
+			if n >= start {
+				line = append(line, chunk...)
+			}
+			if errors.Is(err, bufio.ErrBufferFull) {
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				eof = true
+			} else if err != nil {
+				return nil, "", fmt.Errorf("failed to read file: %v", err)
+			}
+			break
+		}
+		if size == 0 && eof {
+			break
+		}
+
+		if n >= start {
+			text := string(line)
+			if n == 1 {
+				text = strings.TrimPrefix(text, string(bomUTF8))
+			}
+			text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+			if !utf8.ValidString(text) {
+				return nil, "", newError(codeInvalidParam, "%s: line %d is not valid UTF-8", path, n)
+			}
+			lines = append(lines, text)
+		}
+		if eof {
+			break
+		}
+	}
+
+	return lines, "list", nil
+}
+
+// getMaxBytes reads params.max_bytes, defaulting to defaultMaxBytes
+func getMaxBytes(params map[string]any) (int64, error) {
+	maxBytes := getInt64(params, "max_bytes", defaultMaxBytes)
+	if maxBytes < 1 || maxBytes > maxMaxBytes {
+		return 0, newError(codeInvalidParam, "max_bytes must be between 1 and %d", maxMaxBytes)
+	}
+	return maxBytes, nil
+}
+
+// readLimited reads length bytes from offset (or to the end of the file when
+// length is negative), failing with LIMIT_EXCEEDED rather than reading more
+// than maxBytes. Whole-file reads go through the read cache.
+func readLimited(path string, offset, length, maxBytes int64) ([]byte, error) {
+	f, err := box.Open(path)
+	if err != nil {
+		return nil, fileError("failed to read file", err)
+	}
+	defer f.Close()
+
+	var info fs.FileInfo
+	if offset == 0 && length < 0 && cache != nil {
+		if info, err = f.Stat(); err != nil {
+			return nil, fmt.Errorf("failed to read file: %v", err)
+		}
+		if data, ok := cache.get(path, info); ok {
+			if int64(len(data)) > maxBytes {
+				return nil, newError(codeLimitExceeded, "%s is larger than max_bytes (%d)", path, maxBytes)
+			}
+			return data, nil
+		}
+	}
+
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to read file: %v", err)
+		}
+	}
+
+	var r io.Reader = f
+	if length >= 0 {
+		if length > maxBytes {
+			return nil, newError(codeLimitExceeded, "%s: length %d exceeds max_bytes (%d)", path, length, maxBytes)
+		}
+		r = io.LimitReader(f, length)
+	}
+
+	// Read one byte past the limit to tell a file of exactly maxBytes from a
+	// larger one
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, newError(codeLimitExceeded, "%s is larger than max_bytes (%d)", path, maxBytes)
+	}
+
+	if info != nil {
+		cache.put(path, info, data)
+	}
+	return data, nil
+}
+
+// decodeText converts file bytes in the given encoding to a string
+func decodeText(data []byte, encoding string, stripBOM bool) (string, error) {
+	switch encoding {
+	case encodingBase64:
+		return base64.StdEncoding.EncodeToString(data), nil
+
+	case encodingLatin1:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes), nil
+
+	case encodingUTF16, encodingUTF16LE, encodingUTF16BE:
+		bigEndian := encoding == encodingUTF16BE
+		switch {
+		case bytes.HasPrefix(data, bomUTF16LE) && encoding != encodingUTF16BE:
+			bigEndian = false
+			data = data[2:]
+		case bytes.HasPrefix(data, bomUTF16BE) && encoding != encodingUTF16LE:
+			bigEndian = true
+			data = data[2:]
+		}
+		if len(data)%2 != 0 {
+			return "", fmt.Errorf("odd number of bytes for UTF-16")
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			if bigEndian {
+				units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+			} else {
+				units[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+			}
+		}
+		return string(utf16.Decode(units)), nil
+
+	default:
+		if stripBOM {
+			data = bytes.TrimPrefix(data, bomUTF8)
+		}
+		if !utf8.Valid(data) {
+			return "", fmt.Errorf("not valid UTF-8; use encoding=latin1 or base64")
+		}
+		return string(data), nil
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// defaultPolicyFile is looked up next to the document being rendered when
+// context.policy_file is not set
+const defaultPolicyFile = ".up-file-policy.json"
+
+// filePolicy is the project policy for the file namespace
+type filePolicy struct {
+	// Root confines every path to this directory, relative to the policy file
+	Root string `json:"root"`
+	// WriteRoot is the only directory write functions may change, relative
+	// to the policy file. Writes also need context.write.
+	WriteRoot string `json:"write_root"`
+}
+
+// sandbox confines file access to a root directory with os.Root, which
+// rejects paths that escape it through .. or symlinks. A nil sandbox allows
+// any path the process can access.
+type sandbox struct {
+	root *os.Root
+	dir  string
+}
+
+// box is the sandbox for the current request, set up by openSandbox
+var box *sandbox
+
+// openSandbox sets up the sandbox from context.root and the policy file
+// (context.policy_file, or .up-file-policy.json beside the document). When
+// both name a root, paths must stay inside both.
+func openSandbox(context map[string]any) (*sandbox, error) {
+	var roots []string
+
+	if root := getString(context, "root", ""); root != "" {
+		roots = append(roots, resolvePath(context, root))
+	}
+
+	policy, policyDir, err := loadPolicy(context)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil && policy.Root != "" {
+		root := policy.Root
+		if !filepath.IsAbs(root) {
+			root = filepath.Join(policyDir, root)
+		}
+		roots = append(roots, root)
+	}
+
+	if len(roots) == 0 {
+		return nil, nil
+	}
+
+	dir, err := innermostRoot(roots)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sandbox root: %v", err)
+	}
+
+	return &sandbox{root: root, dir: dir}, nil
+}
+
+// loadPolicy reads the file namespace policy, returning it with the
+// directory relative paths in it resolve against. A missing default policy
+// file is not an error.
+func loadPolicy(context map[string]any) (*filePolicy, string, error) {
+	path := getString(context, "policy_file", "")
+	explicit := path != ""
+	if explicit {
+		path = resolvePath(context, path)
+	} else if doc := getString(context, "file", ""); doc != "" {
+		path = filepath.Join(filepath.Dir(doc), defaultPolicyFile)
+	} else {
+		return nil, "", nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read policy file: %v", err)
+	}
+
+	var policy filePolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, "", fmt.Errorf("invalid policy file %s: %v", path, err)
+	}
+
+	return &policy, filepath.Dir(path), nil
+}
+
+// innermostRoot returns the root contained in all the others
+func innermostRoot(roots []string) (string, error) {
+	var inner string
+	for _, root := range roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case inner == "", within(inner, abs):
+			inner = abs
+		case within(abs, inner):
+		default:
+			return "", fmt.Errorf("sandbox roots %s and %s do not overlap", inner, abs)
+		}
+	}
+	return inner, nil
+}
+
+// within reports whether path is dir or lies beneath it
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolvePath resolves a relative path against the directory of the
+// document being rendered (context.file), falling back to the working
+// directory.
+func resolvePath(context map[string]any, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	if doc := getString(context, "file", ""); doc != "" {
+		return filepath.Join(filepath.Dir(doc), path)
+	}
+	return path
+}
+
+// name converts path to a name relative to the sandbox root, rejecting
+// paths that lie outside it before os.Root sees them
+func (s *sandbox) name(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if !within(s.dir, abs) {
+		return "", denied(path)
+	}
+	rel, err := filepath.Rel(s.dir, abs)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", denied(path)
+	}
+	return rel, nil
+}
+
+// check wraps an os.Root error. Lexical escapes are caught by name, so a
+// failure other than a missing file on a path that goes through a symlink is
+// os.Root refusing to leave the root, and is reported as PERMISSION_DENIED.
+func (s *sandbox) check(path string, err error) error {
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if name, nameErr := s.name(path); nameErr == nil && s.throughSymlink(name) {
+		return denied(path)
+	}
+	return err
+}
+
+// throughSymlink reports whether name or any of its parent directories is a
+// symlink
+func (s *sandbox) throughSymlink(name string) bool {
+	prefix := ""
+	for _, part := range strings.Split(name, string(filepath.Separator)) {
+		prefix = filepath.Join(prefix, part)
+		info, err := s.root.Lstat(prefix)
+		if err != nil {
+			return false
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func denied(path string) error {
+	return newError(codePermissionDenied, "access to %s is outside the sandbox root", path)
+}
+
+// Open opens a file for reading
+func (s *sandbox) Open(path string) (*os.File, error) {
+	if s == nil {
+		return os.Open(path)
+	}
+	name, err := s.name(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.root.Open(name)
+	return f, s.check(path, err)
+}
+
+// Stat returns file info, following symlinks
+func (s *sandbox) Stat(path string) (os.FileInfo, error) {
+	if s == nil {
+		return os.Stat(path)
+	}
+	name, err := s.name(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.root.Stat(name)
+	return info, s.check(path, err)
+}
+
+// Lstat returns file info without following a final symlink
+func (s *sandbox) Lstat(path string) (os.FileInfo, error) {
+	if s == nil {
+		return os.Lstat(path)
+	}
+	name, err := s.name(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.root.Lstat(name)
+	return info, s.check(path, err)
+}
+
+// ReadFile reads a whole file
+func (s *sandbox) ReadFile(path string) ([]byte, error) {
+	if s == nil {
+		return os.ReadFile(path)
+	}
+	name, err := s.name(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.root.ReadFile(name)
+	return data, s.check(path, err)
+}
+
+// ReadDir lists a directory
+func (s *sandbox) ReadDir(path string) ([]os.DirEntry, error) {
+	if s == nil {
+		return os.ReadDir(path)
+	}
+	f, err := s.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := f.ReadDir(-1)
+	// Sorted by name, as os.ReadDir returns them
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, err
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// handleJSON reads a JSON file into UP values
+func handleJSON(params map[string]any, context map[string]any) (any, string, error) {
+	return readStructured(params, context, func(data []byte) (any, error) {
+		var v any
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+}
+
+// handleYAML reads the first document of a YAML file
+func handleYAML(params map[string]any, context map[string]any) (any, string, error) {
+	return readStructured(params, context, func(data []byte) (any, error) {
+		var v any
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+}
+
+func handleTOML(params map[string]any, context map[string]any) (any, string, error) {
+	return readStructured(params, context, func(data []byte) (any, error) {
+		var v map[string]any
+		if err := toml.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+}
+
+// handleCSV reads a CSV file as a list of rows. With header (the default)
+// each row is a block keyed by the first line's column names, otherwise a
+// list of fields.
+func handleCSV(params map[string]any, context map[string]any) (any, string, error) {
+	header := getBool(params, "header", true)
+	delimiter := getString(params, "delimiter", ",")
+	comma, size := utf8.DecodeRuneInString(delimiter)
+	if size == 0 || size != len(delimiter) {
+		return nil, "", newError(codeInvalidParam, "delimiter must be a single character")
+	}
+
+	return readStructured(params, context, func(data []byte) (any, error) {
+		r := csv.NewReader(bytes.NewReader(data))
+		r.Comma = comma
+		r.FieldsPerRecord = -1
+		records, err := r.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+
+		rows := make([]any, 0, len(records))
+		if !header {
+			for _, record := range records {
+				fields := make([]any, len(record))
+				for i, field := range record {
+					fields[i] = field
+				}
+				rows = append(rows, fields)
+			}
+			return rows, nil
+		}
+
+		if len(records) == 0 {
+			return rows, nil
+		}
+		columns := records[0]
+		for _, record := range records[1:] {
+			row := make(map[string]any, len(columns))
+			for i, column := range columns {
+				if i < len(record) {
+					row[column] = record[i]
+				} else {
+					row[column] = ""
+				}
+			}
+			rows = append(rows, row)
+		}
+		return rows, nil
+	})
+}
+
+// handleINI reads an INI file into a block of sections. Keys before the
+// first section are top-level values.
+func handleINI(params map[string]any, context map[string]any) (any, string, error) {
+	return readStructured(params, context, parseINI)
+}
+
+// parseINI parses INI syntax:
+//
+//	; or # comments
+//	key = value
+//	[section]
+//	key: "quoted value"
+func parseINI(data []byte) (any, error) {
+	result := make(map[string]any)
+	current := result
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == ';' || text[0] == '#' {
+			continue
+		}
+
+		if text[0] == '[' {
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("line %d: unterminated section header", line)
+			}
+			name := strings.TrimSpace(text[1 : len(text)-1])
+			section, ok := result[name].(map[string]any)
+			if !ok {
+				section = make(map[string]any)
+				result[name] = section
+			}
+			current = section
+			continue
+		}
+
+		i := strings.IndexAny(text, "=:")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", line)
+		}
+		key := strings.TrimSpace(text[:i])
+		value := strings.TrimSpace(text[i+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		current[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// readStructured reads params.path, decodes it with parse and returns the
+// sub-tree named by params.select
+func readStructured(params map[string]any, context map[string]any, parse func([]byte) (any, error)) (any, string, error) {
+	path := getString(params, "path", "")
+	if path == "" {
+		return nil, "", fmt.Errorf("path parameter required")
+	}
+	path = resolvePath(context, path)
+
+	maxBytes, err := getMaxBytes(params)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := readLimited(path, 0, -1, maxBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Editors on Windows often save a UTF-8 byte order mark
+	data = bytes.TrimPrefix(data, bomUTF8)
+
+	value, err := parse(data)
+	if err != nil {
+		return nil, "", newError(codeInvalidParam, "failed to parse %s: %v", path, err)
+	}
+	if value, err = normalizeValue(value); err != nil {
+		return nil, "", newError(codeInvalidParam, "failed to parse %s: %v", path, err)
+	}
+
+	if selector := getString(params, "select", ""); selector != "" {
+		if value, err = selectValue(value, selector); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return value, valueType(value), nil
+}
+
+// normalizeValue converts decoded values into the shapes the response
+// encoder expects: string-keyed maps, []any lists and RFC 3339 timestamps.
+// NaN and infinities, valid in YAML and TOML, have no JSON form and are
+// rejected.
+func normalizeValue(v any) (any, error) {
+	var err error
+	switch val := v.(type) {
+	case map[string]any:
+		for k, item := range val {
+			if val[k], err = normalizeValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return val, nil
+	case map[any]any:
+		m := make(map[string]any, len(val))
+		for k, item := range val {
+			if m[fmt.Sprint(k)], err = normalizeValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []any:
+		for i, item := range val {
+			if val[i], err = normalizeValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return val, nil
+	case []map[string]any:
+		list := make([]any, len(val))
+		for i, item := range val {
+			if list[i], err = normalizeValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case float64:
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return nil, fmt.Errorf("non-finite number %v is not supported", val)
+		}
+	case time.Time:
+		// TOML local dates and times are decoded in marker time zones
+		switch val.Location().String() {
+		case "date-local":
+			return val.Format(time.DateOnly), nil
+		case "time-local":
+			return val.Format("15:04:05.999999999"), nil
+		case "datetime-local":
+			return val.Format("2006-01-02T15:04:05.999999999"), nil
+		}
+		return val.Format(time.RFC3339Nano), nil
+	}
+	return v, nil
+}
+
+// valueType returns the UP type of a decoded value
+func valueType(v any) string {
+	switch val := v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int, int64, uint64:
+		return "int"
+	case float64:
+		if val == math.Trunc(val) && math.Abs(val) < 1<<53 {
+			return "int"
+		}
+		return "float"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "block"
+	default:
+		return "null"
+	}
+}
+
+// selectValue walks a selector such as `dependencies.react`,
+// `items[0].name` or `scripts["build:prod"]` into v
+func selectValue(v any, selector string) (any, error) {
+	segments, err := parseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, segment := range segments {
+		at := strings.Join(segments[:i+1], ".")
+		switch node := v.(type) {
+		case map[string]any:
+			child, ok := node[segment]
+			if !ok {
+				return nil, newError(codeNotFound, "select: %s not found", at)
+			}
+			v = child
+		case []any:
+			index, err := strconv.Atoi(segment)
+			if err != nil {
+				return nil, newError(codeInvalidParam, "select: %s: expected a list index", at)
+			}
+			if index < 0 {
+				index += len(node)
+			}
+			if index < 0 || index >= len(node) {
+				return nil, newError(codeNotFound, "select: %s: index out of range (length %d)", at, len(node))
+			}
+			v = node[index]
+		default:
+			return nil, newError(codeNotFound, "select: %s not found", at)
+		}
+	}
+
+	return v, nil
+}
+
+// parseSelector splits a selector into keys and indexes. Dots separate
+// keys, [n] is an index, and ["key"] quotes keys containing dots or brackets.
+func parseSelector(selector string) ([]string, error) {
+	var segments []string
+	s := selector
+	for s != "" {
+		switch {
+		case strings.HasPrefix(s, `["`):
+			end := strings.Index(s, `"]`)
+			if end < 0 {
+				return nil, newError(codeInvalidParam, "select: unterminated quoted key in %q", selector)
+			}
+			segments = append(segments, s[2:end])
+			s = s[end+2:]
+		case s[0] == '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, newError(codeInvalidParam, "select: unterminated index in %q", selector)
+			}
+			segments = append(segments, s[1:end])
+			s = s[end+1:]
+		default:
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				return nil, newError(codeInvalidParam, "select: empty key in %q", selector)
+			}
+			segments = append(segments, s[:end])
+			s = s[end:]
+		}
+		if strings.HasPrefix(s, ".") {
+			s = s[1:]
+			if s == "" {
+				return nil, newError(codeInvalidParam, "select: empty key in %q", selector)
+			}
+		}
+	}
+	return segments, nil
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// upParser parses the static subset of UP used by include(): comments,
+// directives, key!type values, { } blocks, [ ] lists, quoted strings and
+// ``` multi-line strings. Namespace calls can't be evaluated inside the
+// plugin, except $file.include, which call handles.
+type upParser struct {
+	file  string
+	lines []string
+	pos   int
+	// base is the document line before lines[0], for blocks parsed out of
+	// a list
+	base int
+	// call evaluates a namespace call found in a value
+	call func(namespace, function string, args map[string]string) (any, error)
+}
+
+var callPattern = regexp.MustCompile(`^\$([A-Za-z_]\w*)\.([A-Za-z_]\w*)(?:\((.*)\))?$`)
+
+func parseUP(file, text string, call func(string, string, map[string]string) (any, error)) (map[string]any, error) {
+	p := &upParser{
+		file:  file,
+		lines: strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n"),
+		call:  call,
+	}
+	return p.parseBlock(false)
+}
+
+func (p *upParser) errorf(line int, format string, args ...any) error {
+	return newError(codeInvalidParam, "%s:%d: %s", p.file, p.base+line, fmt.Sprintf(format, args...))
+}
+
+// parseBlock parses entries up to the closing } (nested) or the end of the
+// document (top level)
+func (p *upParser) parseBlock(nested bool) (map[string]any, error) {
+	block := make(map[string]any)
+	start := p.pos
+	for p.pos < len(p.lines) {
+		line := strings.TrimSpace(p.lines[p.pos])
+		p.pos++
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "}" {
+			if !nested {
+				return nil, p.errorf(p.pos, "unexpected }")
+			}
+			return block, nil
+		}
+		if strings.HasPrefix(line, "!") {
+			// Directives such as !use apply to the including document
+			if strings.Contains(line, "[") && !strings.Contains(line, "]") {
+				p.skipTo("]")
+			}
+			continue
+		}
+
+		key, rest, _ := strings.Cut(line, " ")
+		name, typ, _ := strings.Cut(key, "!")
+		value, err := p.parseValue(strings.TrimSpace(rest), typ, p.pos)
+		if err != nil {
+			return nil, err
+		}
+		block[name] = value
+	}
+	if nested {
+		return nil, p.errorf(start, "unterminated block")
+	}
+	return block, nil
+}
+
+// skipTo advances past the next line containing end
+func (p *upParser) skipTo(end string) {
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		p.pos++
+		if strings.Contains(line, end) {
+			return
+		}
+	}
+}
+
+func (p *upParser) parseValue(rest, typ string, line int) (any, error) {
+	switch {
+	case rest == "":
+		return nil, nil
+	case rest == "{":
+		return p.parseBlock(true)
+	case strings.HasPrefix(rest, "{") && strings.HasSuffix(rest, "}"):
+		return p.parseInlineBlock(rest, line)
+	case strings.HasPrefix(rest, "```"):
+		return p.parseMultiline(line)
+	case strings.HasPrefix(rest, "["):
+		text := rest
+		for depth(text) > 0 {
+			if p.pos >= len(p.lines) {
+				return nil, p.errorf(line, "unterminated list")
+			}
+			text += "\n" + p.lines[p.pos]
+			p.pos++
+		}
+		return p.parseList(strings.TrimSpace(text), line)
+	}
+
+	value, err := p.parseItem(rest, line)
+	if err != nil {
+		return nil, err
+	}
+	return p.convert(value, typ, line)
+}
+
+// parseMultiline reads a ``` string, removing the indentation common to
+// its lines
+func (p *upParser) parseMultiline(line int) (any, error) {
+	var body []string
+	for {
+		if p.pos >= len(p.lines) {
+			return nil, p.errorf(line, "unterminated ``` string")
+		}
+		text := p.lines[p.pos]
+		p.pos++
+		if strings.TrimSpace(text) == "```" {
+			break
+		}
+		body = append(body, text)
+	}
+
+	indent := -1
+	for _, text := range body {
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		n := len(text) - len(strings.TrimLeft(text, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i, text := range body {
+		if len(text) >= indent && indent > 0 {
+			body[i] = text[indent:]
+		} else {
+			body[i] = strings.TrimLeft(text, " \t")
+		}
+	}
+	return strings.Join(body, "\n"), nil
+}
+
+// parseList parses a [ ] list whose items are separated by commas or
+// newlines
+func (p *upParser) parseList(text string, line int) (any, error) {
+	if !strings.HasSuffix(text, "]") {
+		return nil, p.errorf(line, "unexpected text after list")
+	}
+	items := []any{}
+	for _, item := range splitTopLevel(text[1:len(text)-1], ",\n") {
+		item = strings.TrimSpace(item)
+		if item == "" || strings.HasPrefix(item, "#") {
+			continue
+		}
+		var value any
+		var err error
+		switch {
+		case strings.HasPrefix(item, "["):
+			value, err = p.parseList(item, line)
+		case strings.HasPrefix(item, "{"):
+			if !strings.HasSuffix(item, "}") {
+				return nil, p.errorf(line, "unterminated block in list")
+			}
+			value, err = p.parseInlineBlock(item, line)
+		default:
+			value, err = p.parseItem(item, line)
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+	return items, nil
+}
+
+// parseInlineBlock parses a { } block given as text, as found in lists.
+// Entries are separated by newlines or, in a one-line block, by commas.
+func (p *upParser) parseInlineBlock(text string, line int) (map[string]any, error) {
+	body := text[1 : len(text)-1]
+	if !strings.Contains(body, "\n") {
+		body = strings.Join(splitTopLevel(body, ","), "\n")
+	}
+	sub := &upParser{
+		file:  p.file,
+		lines: strings.Split(body, "\n"),
+		base:  p.base + line - 1,
+		call:  p.call,
+	}
+	return sub.parseBlock(false)
+}
+
+// parseItem parses a scalar or a namespace call
+func (p *upParser) parseItem(text string, line int) (any, error) {
+	if !strings.HasPrefix(text, "$") {
+		return unquote(text), nil
+	}
+
+	m := callPattern.FindStringSubmatch(text)
+	if m == nil {
+		return nil, p.errorf(line, "malformed namespace call %s", text)
+	}
+	args := make(map[string]string)
+	if m[3] != "" {
+		for i, arg := range splitTopLevel(m[3], ",") {
+			arg = strings.TrimSpace(arg)
+			if name, value, ok := strings.Cut(arg, "="); ok && isIdentifier(name) {
+				args[name] = unquote(strings.TrimSpace(value))
+			} else {
+				args[strconv.Itoa(i)] = unquote(arg)
+			}
+		}
+	}
+
+	value, err := p.call(m[1], m[2], args)
+	if err != nil {
+		if errorCode(err) != "" {
+			return nil, err
+		}
+		return nil, p.errorf(line, "%v", err)
+	}
+	return value, nil
+}
+
+// convert applies a type annotation to a scalar
+func (p *upParser) convert(value any, typ string, line int) (any, error) {
+	s, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+
+	var err error
+	switch typ {
+	case "int":
+		if value, err = strconv.ParseInt(s, 10, 64); err == nil {
+			return value, nil
+		}
+	case "float":
+		if value, err = strconv.ParseFloat(s, 64); err == nil {
+			return value, nil
+		}
+	case "bool":
+		if value, err = strconv.ParseBool(s); err == nil {
+			return value, nil
+		}
+	default:
+		return s, nil
+	}
+	return nil, p.errorf(line, "%q is not a valid %s", s, typ)
+}
+
+// splitTopLevel splits s at any of seps outside quotes and brackets
+func splitTopLevel(s, seps string) []string {
+	var parts []string
+	level := 0
+	inQuote := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case inQuote:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inQuote = false
+			}
+		case c == '"':
+			inQuote = true
+		case c == '[' || c == '{' || c == '(':
+			level++
+		case c == ']' || c == '}' || c == ')':
+			level--
+		case level == 0 && strings.IndexByte(seps, c) >= 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// depth returns how many brackets remain open at the end of s
+func depth(s string) int {
+	n := 0
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case inQuote:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inQuote = false
+			}
+		case c == '"':
+			inQuote = true
+		case c == '[':
+			n++
+		case c == ']':
+			n--
+		}
+	}
+	return n
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		if u, err := strconv.Unquote(s); err == nil {
+			return u
+		}
+	}
+	return s
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if c != '_' && !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
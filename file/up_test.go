@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// stubCall stands in for namespace calls, which include() can't evaluate
+func stubCall(namespace, function string, args map[string]string) (any, error) {
+	return "$" + namespace + "." + function, nil
+}
+
+func TestParseBlocksInLists(t *testing.T) {
+	tests := []struct {
+		text string
+		want map[string]any
+	}{
+		{
+			"servers [ { host a } ]",
+			map[string]any{"servers": []any{map[string]any{"host": "a"}}},
+		},
+		{
+			"servers [{ host a, port!int 80 }, { host b }]",
+			map[string]any{"servers": []any{
+				map[string]any{"host": "a", "port": int64(80)},
+				map[string]any{"host": "b"},
+			}},
+		},
+		{
+			"servers [\n  {\n    host a\n    tags [x, y]\n    tls {\n      enabled!bool true\n    }\n  }\n  plain\n]",
+			map[string]any{"servers": []any{
+				map[string]any{
+					"host": "a",
+					"tags": []any{"x", "y"},
+					"tls":  map[string]any{"enabled": true},
+				},
+				"plain",
+			}},
+		},
+		{
+			"server { host a, port!int 80 }",
+			map[string]any{"server": map[string]any{"host": "a", "port": int64(80)}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseUP("test.up", tt.text, stubCall)
+		if err != nil {
+			t.Errorf("parseUP(%q): %v", tt.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseUP(%q) = %#v, want %#v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseRepoSchema(t *testing.T) {
+	data, err := os.ReadFile("../greeting/greeting.up-schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := parseUP("greeting.up-schema", string(data), stubCall)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hello := doc["functions"].(map[string]any)["hello"].(map[string]any)
+	examples, ok := hello["examples"].([]any)
+	if !ok || len(examples) != 3 {
+		t.Fatalf("hello.examples = %#v, want 3 items", hello["examples"])
+	}
+	want := []map[string]any{
+		{"call": "$greeting.hello", "result": "Hello, Alice!"},
+		{"call": "$greeting.hello(Bob, excited=false)", "result": "Hello, Bob"},
+		{"call": "$greeting.hello", "result": "Hello, World!", "note": "Uses default name"},
+	}
+	for i, example := range examples {
+		if !reflect.DeepEqual(example, want[i]) {
+			t.Errorf("hello.examples[%d] = %#v, want %#v", i, example, want[i])
+		}
+	}
+}
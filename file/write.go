@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Actions reported by the write functions
+const (
+	actionCreate    = "create"
+	actionOverwrite = "overwrite"
+	actionAppend    = "append"
+	actionExists    = "exists"
+)
+
+// openWriteRoot returns the sandbox write functions are confined to. Writes
+// need both the write capability (context.write) and a write root
+// (context.write_root or write_root in the policy file); a write root must
+// lie inside the read sandbox, if there is one.
+func openWriteRoot(context map[string]any) (*sandbox, error) {
+	if !getBool(context, "write", false) {
+		return nil, newError(codePermissionDenied, "file writes are disabled; the request context must grant write")
+	}
+
+	var roots []string
+	if root := getString(context, "write_root", ""); root != "" {
+		roots = append(roots, resolvePath(context, root))
+	}
+	policy, policyDir, err := loadPolicy(context)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil && policy.WriteRoot != "" {
+		root := policy.WriteRoot
+		if !filepath.IsAbs(root) {
+			root = filepath.Join(policyDir, root)
+		}
+		roots = append(roots, root)
+	}
+	if len(roots) == 0 {
+		return nil, newError(codePermissionDenied, "file writes need a write root (context.write_root or write_root in the policy file)")
+	}
+
+	dir, err := innermostRoot(roots)
+	if err != nil {
+		return nil, err
+	}
+	if box != nil && !within(box.dir, dir) {
+		return nil, newError(codePermissionDenied, "write root %s is outside the sandbox root", dir)
+	}
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write root: %v", err)
+	}
+	return &sandbox{root: root, dir: dir}, nil
+}
+
+// writeError describes a failed write, reporting paths outside the write
+// root as PERMISSION_DENIED
+func writeError(path string, err error) error {
+	if errorCode(err) == codePermissionDenied {
+		return newError(codePermissionDenied, "writing %s is outside the write root", path)
+	}
+	return fileError("failed to write "+path, err)
+}
+
+func handleWrite(params map[string]any, context map[string]any) (any, string, error) {
+	return writeContent(params, context, false)
+}
+
+func handleAppend(params map[string]any, context map[string]any) (any, string, error) {
+	return writeContent(params, context, true)
+}
+
+// writeContent writes or appends params.content to params.path by writing a
+// temporary file beside it and renaming it into place, so readers never see
+// a partial file
+func writeContent(params map[string]any, context map[string]any, appending bool) (any, string, error) {
+	path := getString(params, "path", "")
+	if path == "" {
+		return nil, "", fmt.Errorf("path parameter required")
+	}
+	content, ok := params["content"].(string)
+	if !ok {
+		return nil, "", fmt.Errorf("content parameter required")
+	}
+
+	var data []byte
+	switch encoding := strings.ToLower(getString(params, "encoding", encodingUTF8)); encoding {
+	case encodingUTF8, "utf8":
+		data = []byte(content)
+	case encodingBase64:
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(content); err != nil {
+			return nil, "", newError(codeInvalidParam, "content is not valid base64: %v", err)
+		}
+	default:
+		return nil, "", newError(codeInvalidParam, "encoding must be utf-8 or base64")
+	}
+
+	perm, hasMode, err := getMode(params, 0o644)
+	if err != nil {
+		return nil, "", err
+	}
+
+	w, err := openWriteRoot(context)
+	if err != nil {
+		return nil, "", err
+	}
+	defer w.root.Close()
+
+	target := resolvePath(context, path)
+	name, err := w.name(target)
+	if err != nil {
+		return nil, "", writeError(path, err)
+	}
+
+	action := actionCreate
+	contents := data
+	info, err := w.root.Stat(name)
+	switch {
+	case err == nil:
+		if info.IsDir() {
+			return nil, "", newError(codeInvalidParam, "%s is a directory", path)
+		}
+		if !hasMode {
+			perm = info.Mode().Perm()
+		}
+		action = actionOverwrite
+		if appending {
+			action = actionAppend
+			existing, err := w.root.ReadFile(name)
+			if err != nil {
+				return nil, "", writeError(path, w.check(target, err))
+			}
+			contents = append(existing, data...)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, "", writeError(path, w.check(target, err))
+	}
+
+	parents := getBool(params, "parents", false)
+	if !parents {
+		// Checked up front so a dry run fails where the write would
+		if _, err := w.root.Stat(filepath.Dir(name)); errors.Is(err, fs.ErrNotExist) {
+			return nil, "", newError(codeNotFound, "%s: parent directory doesn't exist; set parents=true to create it", path)
+		} else if err != nil {
+			return nil, "", writeError(path, w.check(target, err))
+		}
+	}
+
+	dryRun := getBool(params, "dry_run", getBool(context, "dry_run", false))
+	if !dryRun {
+		if parents {
+			if err := w.root.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+				return nil, "", writeError(path, w.check(target, err))
+			}
+		}
+		if err := w.replace(name, contents, perm); err != nil {
+			return nil, "", writeError(path, w.check(target, err))
+		}
+	}
+
+	result := map[string]any{
+		"path":    path,
+		"action":  action,
+		"bytes":   len(data),
+		"size":    len(contents),
+		"mode":    fmt.Sprintf("%04o", perm),
+		"dry_run": dryRun,
+	}
+	if dryRun {
+		result["content"] = content
+	}
+	return result, "block", nil
+}
+
+// handleMkdir creates a directory and any missing parents
+func handleMkdir(params map[string]any, context map[string]any) (any, string, error) {
+	path := getString(params, "path", "")
+	if path == "" {
+		return nil, "", fmt.Errorf("path parameter required")
+	}
+	perm, _, err := getMode(params, 0o755)
+	if err != nil {
+		return nil, "", err
+	}
+
+	w, err := openWriteRoot(context)
+	if err != nil {
+		return nil, "", err
+	}
+	defer w.root.Close()
+
+	target := resolvePath(context, path)
+	name, err := w.name(target)
+	if err != nil {
+		return nil, "", writeError(path, err)
+	}
+
+	action := actionCreate
+	info, err := w.root.Stat(name)
+	switch {
+	case err == nil:
+		if !info.IsDir() {
+			return nil, "", newError(codeInvalidParam, "%s exists and is not a directory", path)
+		}
+		action = actionExists
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, "", writeError(path, w.check(target, err))
+	}
+
+	dryRun := getBool(params, "dry_run", getBool(context, "dry_run", false))
+	if !dryRun && action == actionCreate {
+		if err := w.root.MkdirAll(name, perm); err != nil {
+			return nil, "", writeError(path, w.check(target, err))
+		}
+	}
+
+	return map[string]any{
+		"path":    path,
+		"action":  action,
+		"mode":    fmt.Sprintf("%04o", perm),
+		"dry_run": dryRun,
+	}, "block", nil
+}
+
+// replace atomically replaces the file name with data
+func (s *sandbox) replace(name string, data []byte, perm fs.FileMode) error {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	dir, base := filepath.Split(name)
+	tmp := filepath.Join(dir, "."+base+".tmp-"+hex.EncodeToString(suffix))
+
+	f, err := s.root.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	// Set the mode explicitly, since OpenFile applies the umask
+	if err == nil {
+		err = s.root.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = s.root.Rename(tmp, name)
+	}
+	if err != nil {
+		s.root.Remove(tmp)
+	}
+	return err
+}
+
+// getMode reads params.mode, an octal permission string such as "0644"
+func getMode(params map[string]any, def fs.FileMode) (fs.FileMode, bool, error) {
+	mode := getString(params, "mode", "")
+	if mode == "" {
+		return def, false, nil
+	}
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0o777 {
+		return 0, false, newError(codeInvalidParam, "mode must be octal permissions such as 0644")
+	}
+	return fs.FileMode(perm), true, nil
+}
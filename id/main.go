@@ -3,14 +3,64 @@ package main
 import (
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"math/big"
+	"math/bits"
 	"os"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
 
+// maxNanoIDSize bounds the length of a single NanoID
+const maxNanoIDSize = 1024
+
+const defaultNanoIDAlphabet = "_-0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// nanoIDPresets are the named alphabets accepted by the preset parameter
+var nanoIDPresets = map[string]string{
+	"numbers":      "0123456789",
+	"lowercase":    "abcdefghijklmnopqrstuvwxyz",
+	"nolookalikes": "346789ABCDEFGHJKLMNPQRTUVWXYabcdefghijkmnpqrtwxyz",
+	"hex":          "0123456789abcdef",
+	"base58":       "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz",
+}
+
+// namespace is the name this plugin is installed under
+const namespace = "id"
+
+// Error codes reported in the code field of error responses
+const (
+	codeInvalidParam  = "INVALID_PARAM"
+	codeLimitExceeded = "LIMIT_EXCEEDED"
+)
+
+// codedError is an error carrying a protocol error code
+type codedError struct {
+	code    string
+	message string
+}
+
+func (e *codedError) Error() string {
+	return e.message
+}
+
+func newError(code, format string, args ...any) error {
+	return &codedError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// errorCode returns the protocol code of err, or "" if it has none
+func errorCode(err error) string {
+	var ce *codedError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	return ""
+}
+
 type Request struct {
 	Function string         `json:"function"`
 	Params   map[string]any `json:"params"`
@@ -21,6 +71,7 @@ type Response struct {
 	Value any    `json:"value"`
 	Type  string `json:"type"`
 	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
 }
 
 func main() {
@@ -34,28 +85,38 @@ func main() {
 	var resultType string
 	var err error
 
-	switch req.Function {
-	case "uuid", "uuid4":
-		result, resultType, err = handleUUID()
-	case "ulid":
-		result, resultType, err = handleULID()
-	case "nanoid":
-		result, resultType, err = handleNanoID(req.Params)
-	case "snowflake":
-		result, resultType, err = handleSnowflake(req.Params)
-	default:
-		sendError(fmt.Sprintf("Unknown function: %s", req.Function))
-		return
+	if getBool(req.Params, "unique", false) {
+		result, resultType, err = generateUnique(req, dispatch)
+	} else {
+		result, resultType, err = dispatch(req)
 	}
 
 	if err != nil {
-		sendError(err.Error())
+		sendErrorCode(err.Error(), errorCode(err))
 		return
 	}
 
 	sendResponse(result, resultType)
 }
 
+// dispatch routes a request to its handler
+func dispatch(req Request) (any, string, error) {
+	switch req.Function {
+	case "uuid", "uuid4":
+		return handleUUID()
+	case "ulid":
+		return handleULID()
+	case "nanoid":
+		return handleNanoID(req.Params)
+	case "nanoid_collision":
+		return handleNanoIDCollision(req.Params)
+	case "snowflake":
+		return handleSnowflake(req.Params)
+	default:
+		return nil, "", fmt.Errorf("Unknown function: %s", req.Function)
+	}
+}
+
 func handleUUID() (any, string, error) {
 	return uuid.New().String(), "uuid", nil
 }
@@ -68,20 +129,84 @@ func handleULID() (any, string, error) {
 
 func handleNanoID(params map[string]any) (any, string, error) {
 	size := getInt(params, "size", 21)
-	alphabet := getString(params, "alphabet", "_-0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	if size < 1 || size > maxNanoIDSize {
+		return nil, "", fmt.Errorf("size must be between 1 and %d", maxNanoIDSize)
+	}
 
-	result := make([]byte, size)
-	for i := 0; i < size; i++ {
-		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
-		if err != nil {
+	alphabet, err := nanoIDAlphabet(params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Draw random bytes and keep only those that fall inside the alphabet
+	// once masked, so every symbol is equally likely (no modulo bias).
+	mask := nanoIDMask(len(alphabet))
+	step := int(math.Ceil(1.6 * float64(mask) * float64(size) / float64(len(alphabet))))
+
+	result := make([]rune, 0, size)
+	buf := make([]byte, step)
+	for len(result) < size {
+		if _, err := rand.Read(buf); err != nil {
 			return nil, "", err
 		}
-		result[i] = alphabet[n.Int64()]
+		for _, b := range buf {
+			idx := int(b) & mask
+			if idx < len(alphabet) {
+				result = append(result, alphabet[idx])
+				if len(result) == size {
+					break
+				}
+			}
+		}
 	}
 
 	return string(result), "string", nil
 }
 
+func handleNanoIDCollision(params map[string]any) (any, string, error) {
+	size := getInt(params, "size", 21)
+	if size < 1 || size > maxNanoIDSize {
+		return nil, "", fmt.Errorf("size must be between 1 and %d", maxNanoIDSize)
+	}
+
+	alphabet, err := nanoIDAlphabet(params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rate := getFloat64(params, "rate", 1000)
+	if rate <= 0 {
+		return nil, "", fmt.Errorf("rate must be positive")
+	}
+
+	hours := 8760.0
+	if period := getString(params, "period", ""); period != "" {
+		d, err := time.ParseDuration(period)
+		if err != nil || d <= 0 {
+			return nil, "", fmt.Errorf("invalid period: %s", period)
+		}
+		hours = d.Hours()
+	}
+
+	// Birthday approximation, worked in log space so large alphabets and
+	// sizes don't overflow: p = 1 - exp(-n^2 / 2N) with N = len^size.
+	logSpace := float64(size) * math.Log(float64(len(alphabet)))
+	logIDs := 0.5 * (math.Ln2 + logSpace + math.Log(-math.Log1p(-0.01)))
+	ids := rate * hours
+	probability := -math.Expm1(-math.Exp(2*math.Log(ids) - math.Ln2 - logSpace))
+
+	result := map[string]any{
+		"alphabet_size": len(alphabet),
+		"size":          size,
+		"entropy_bits":  logSpace / math.Ln2,
+		"ids_for_1pct":  finite(math.Exp(logIDs)),
+		"years_to_1pct": finite(math.Exp(logIDs) / rate / 8760),
+		"probability":   probability,
+	}
+
+	return result, "block", nil
+}
+
 func handleSnowflake(params map[string]any) (any, string, error) {
 	// Simplified Snowflake ID (timestamp + worker + sequence)
 	timestamp := getTimestamp()
@@ -94,6 +219,58 @@ func handleSnowflake(params map[string]any) (any, string, error) {
 
 // Helper functions
 
+// nanoIDAlphabet resolves the alphabet from the alphabet or preset parameter
+// and checks that it can produce unbiased IDs.
+func nanoIDAlphabet(params map[string]any) ([]rune, error) {
+	alphabet := getString(params, "alphabet", "")
+	preset := getString(params, "preset", "")
+
+	switch {
+	case alphabet != "" && preset != "":
+		return nil, fmt.Errorf("alphabet and preset cannot both be set")
+	case preset != "":
+		a, ok := nanoIDPresets[preset]
+		if !ok {
+			return nil, fmt.Errorf("unknown preset: %s", preset)
+		}
+		alphabet = a
+	case alphabet == "":
+		alphabet = defaultNanoIDAlphabet
+	}
+
+	if !utf8.ValidString(alphabet) {
+		return nil, fmt.Errorf("alphabet must be valid UTF-8")
+	}
+
+	runes := []rune(alphabet)
+	if len(runes) < 2 || len(runes) > 256 {
+		return nil, fmt.Errorf("alphabet must contain between 2 and 256 characters")
+	}
+
+	seen := make(map[rune]bool, len(runes))
+	for _, r := range runes {
+		if seen[r] {
+			return nil, fmt.Errorf("alphabet contains duplicate character %q", r)
+		}
+		seen[r] = true
+	}
+
+	return runes, nil
+}
+
+// nanoIDMask returns the smallest 2^n-1 mask covering an alphabet of size n
+func nanoIDMask(n int) int {
+	return (2 << (bits.Len(uint(n-1)) - 1)) - 1
+}
+
+// finite clamps infinities so the value can be encoded as JSON
+func finite(f float64) float64 {
+	if math.IsInf(f, 1) || math.IsNaN(f) {
+		return math.MaxFloat64
+	}
+	return f
+}
+
 func getString(params map[string]any, key, defaultValue string) string {
 	if v, ok := params[key]; ok {
 		if s, ok := v.(string); ok {
@@ -129,6 +306,20 @@ func getInt64(params map[string]any, key string, defaultValue int64) int64 {
 	return defaultValue
 }
 
+func getFloat64(params map[string]any, key string, defaultValue float64) float64 {
+	if v, ok := params[key]; ok {
+		switch val := v.(type) {
+		case float64:
+			return val
+		case int:
+			return float64(val)
+		case int64:
+			return float64(val)
+		}
+	}
+	return defaultValue
+}
+
 func getTimestamp() int64 {
 	return time.Now().UnixMilli()
 }
@@ -144,6 +335,15 @@ func getRandomInt64(max int64) int64 {
 	return n.Int64()
 }
 
+func getBool(params map[string]any, key string, defaultValue bool) bool {
+	if v, ok := params[key]; ok {
+		if b, ok := v.(bool); ok {
+			return b
+		}
+	}
+	return defaultValue
+}
+
 func sendResponse(value any, valueType string) {
 	resp := Response{
 		Value: value,
@@ -156,8 +356,13 @@ func sendResponse(value any, valueType string) {
 }
 
 func sendError(message string) {
+	sendErrorCode(message, "")
+}
+
+func sendErrorCode(message, code string) {
 	resp := Response{
 		Error: message,
+		Code:  code,
 	}
 	if err := json.NewEncoder(os.Stdout).Encode(resp); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to encode error response: %v\n", err)
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// defaultUniqueAttempts is how many values are tried before giving up
+	defaultUniqueAttempts = 100
+	maxUniqueAttempts     = 10000
+
+	// lockTimeout bounds how long to wait for another process holding the state file
+	lockTimeout = 5 * time.Second
+	// staleLockAge is the age after which an abandoned lock file is removed
+	staleLockAge = 30 * time.Second
+)
+
+// uniqueState records the values issued so far, grouped by scope key. Values
+// are stored as SHA-256 hashes so passwords and keys never reach the disk.
+type uniqueState struct {
+	Scopes map[string][]string `json:"scopes"`
+}
+
+// generateUnique calls generate until it yields a value that has not been
+// issued before in the same scope. Issued values are persisted in a state
+// file so uniqueness holds across the separate plugin invocations of a render.
+func generateUnique(req Request, generate func(Request) (any, string, error)) (any, string, error) {
+	path, err := uniqueStatePath(req.Context)
+	if err != nil {
+		return nil, "", err
+	}
+
+	scope := getString(req.Context, "unique_key", namespace+"."+req.Function)
+	attempts := getInt(req.Params, "max_attempts", defaultUniqueAttempts)
+	if attempts < 1 || attempts > maxUniqueAttempts {
+		return nil, "", newError(codeInvalidParam, "max_attempts must be between 1 and %d", maxUniqueAttempts)
+	}
+
+	unlock, err := lockStateFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer unlock()
+
+	state, err := loadUniqueState(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	issued := make(map[string]bool, len(state.Scopes[scope]))
+	for _, v := range state.Scopes[scope] {
+		issued[v] = true
+	}
+
+	for i := 0; i < attempts; i++ {
+		value, valueType, err := generate(req)
+		if err != nil {
+			return nil, "", err
+		}
+
+		key := issuedKey(value)
+		if issued[key] {
+			continue
+		}
+
+		state.Scopes[scope] = append(state.Scopes[scope], key)
+		if err := saveUniqueState(path, state); err != nil {
+			return nil, "", err
+		}
+		return value, valueType, nil
+	}
+
+	return nil, "", newError(codeLimitExceeded, "no unique value for %q after %d attempts", scope, attempts)
+}
+
+// issuedKey is the form of a value stored in the state file
+func issuedKey(value any) string {
+	sum := sha256.Sum256([]byte(fmt.Sprint(value)))
+	return hex.EncodeToString(sum[:])
+}
+
+// uniqueStatePath resolves the state file from context.state_file, or from
+// context.session as a file in the temp directory.
+func uniqueStatePath(context map[string]any) (string, error) {
+	if path := getString(context, "state_file", ""); path != "" {
+		return path, nil
+	}
+	if session := getString(context, "session", ""); session != "" {
+		return filepath.Join(os.TempDir(), "up-ns-unique-"+filepath.Base(session)+".json"), nil
+	}
+	return "", newError(codeInvalidParam, "unique requires context.state_file or context.session")
+}
+
+func loadUniqueState(path string) (*uniqueState, error) {
+	state := &uniqueState{Scopes: map[string][]string{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid state file: %v", err)
+	}
+	if state.Scopes == nil {
+		state.Scopes = map[string][]string{}
+	}
+	return state, nil
+}
+
+// saveUniqueState writes the state to a temporary file and renames it into
+// place so a crash never leaves a truncated state file behind.
+func saveUniqueState(path string, state *uniqueState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	return nil
+}
+
+// lockStateFile takes an exclusive lock next to the state file so concurrent
+// invocations don't lose each other's updates.
+func lockStateFile(path string) (func(), error) {
+	lock := path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock state file: %v", err)
+		}
+
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for state file lock %s", lock)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
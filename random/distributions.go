@@ -0,0 +1,166 @@
+package main
+
+import (
+	"math"
+)
+
+// The samplers below are implemented on top of source rather than taken
+// from math/rand/v2 so that seeded output stays stable across Go releases.
+
+// normal samples N(0, 1) with the Marsaglia polar method
+func normal(src source) float64 {
+	for {
+		u := 2*float64n(src) - 1
+		v := 2*float64n(src) - 1
+		s := u*u + v*v
+		if s > 0 && s < 1 {
+			return u * math.Sqrt(-2*math.Log(s)/s)
+		}
+	}
+}
+
+// exponential samples Exp(1) by inversion
+func exponential(src source) float64 {
+	return -math.Log1p(-float64n(src))
+}
+
+// poisson samples Poisson(lambda): Knuth's multiplication method for small
+// lambda, Hörmann's PTRS transformed rejection otherwise.
+func poisson(src source, lambda float64) int64 {
+	if lambda == 0 {
+		return 0
+	}
+
+	if lambda < 10 {
+		limit := math.Exp(-lambda)
+		k := int64(0)
+		p := float64n(src)
+		for p > limit {
+			k++
+			p *= float64n(src)
+		}
+		return k
+	}
+
+	slam := math.Sqrt(lambda)
+	loglam := math.Log(lambda)
+	b := 0.931 + 2.53*slam
+	a := -0.059 + 0.02483*b
+	invalpha := 1.1239 + 1.1328/(b-3.4)
+	vr := 0.9277 - 3.6224/(b-2)
+
+	for {
+		u := float64n(src) - 0.5
+		v := float64n(src)
+		us := 0.5 - math.Abs(u)
+		k := math.Floor((2*a/us+b)*u + lambda + 0.43)
+		if us >= 0.07 && v <= vr {
+			return int64(k)
+		}
+		if k < 0 || (us < 0.013 && v > us) {
+			continue
+		}
+		lg, _ := math.Lgamma(k + 1)
+		if math.Log(v)+math.Log(invalpha)-math.Log(a/(us*us)+b) <= -lambda+k*loglam-lg {
+			return int64(k)
+		}
+	}
+}
+
+// binomial samples Binomial(n, p): inversion when n*p is small, Hörmann's
+// BTRS transformed rejection otherwise.
+func binomial(src source, n int64, p float64) int64 {
+	if p > 0.5 {
+		return n - binomial(src, n, 1-p)
+	}
+	if n == 0 || p == 0 {
+		return 0
+	}
+
+	q := 1 - p
+	if float64(n)*p < 10 {
+		// Count geometric waiting times between successes until they pass n
+		logq := math.Log1p(-p)
+		k, trials := int64(0), int64(0)
+		for {
+			gap := math.Max(math.Ceil(math.Log(1-float64n(src))/logq), 1)
+			if gap > float64(n-trials) {
+				return k
+			}
+			trials += int64(gap)
+			k++
+		}
+	}
+
+	nf := float64(n)
+	spq := math.Sqrt(nf * p * q)
+	b := 1.15 + 2.53*spq
+	a := -0.0873 + 0.0248*b + 0.01*p
+	c := nf*p + 0.5
+	vr := 0.92 - 4.2/b
+	alpha := (2.83 + 5.1/b) * spq
+	lpq := math.Log(p / q)
+	m := math.Floor((nf + 1) * p)
+	lgm, _ := math.Lgamma(m + 1)
+	lgnm, _ := math.Lgamma(nf - m + 1)
+	h := lgm + lgnm
+
+	for {
+		u := float64n(src) - 0.5
+		v := float64n(src)
+		us := 0.5 - math.Abs(u)
+		k := math.Floor((2*a/us+b)*u + c)
+		if k < 0 || k > nf {
+			continue
+		}
+		if us >= 0.07 && v <= vr {
+			return int64(k)
+		}
+		v = math.Log(v * alpha / (a/(us*us) + b))
+		lgk, _ := math.Lgamma(k + 1)
+		lgnk, _ := math.Lgamma(nf - k + 1)
+		if v <= h-lgk-lgnk+(k-m)*lpq {
+			return int64(k)
+		}
+	}
+}
+
+// zipf samples values in [0, imax] with P(k) proportional to (v+k)^-s using
+// Hörmann and Derflinger's rejection-inversion method. Requires s > 1, v >= 1.
+func zipf(src source, s, v float64, imax uint64) uint64 {
+	oneMinusQ := 1 - s
+	oneMinusQInv := 1 / oneMinusQ
+	h := func(x float64) float64 {
+		return math.Exp(oneMinusQ*math.Log(v+x)) * oneMinusQInv
+	}
+	hinv := func(x float64) float64 {
+		return math.Exp(oneMinusQInv*math.Log(oneMinusQ*x)) - v
+	}
+
+	hxm := h(float64(imax) + 0.5)
+	hx0MinusHxm := h(0.5) - math.Exp(math.Log(v)*(-s)) - hxm
+	threshold := 1 - hinv(h(1.5)-math.Exp(-s*math.Log(v+1)))
+
+	for {
+		ur := hxm + float64n(src)*hx0MinusHxm
+		x := hinv(ur)
+		k := math.Floor(x + 0.5)
+		if k-x <= threshold {
+			return uint64(k)
+		}
+		if ur >= h(k+0.5)-math.Exp(-math.Log(k+v)*s) {
+			return uint64(k)
+		}
+	}
+}
+
+// triangular samples the triangular distribution on [lo, hi] peaking at
+// peak by inverting its CDF
+func triangular(src source, lo, peak, hi float64) float64 {
+	u := float64n(src)
+	split := (peak - lo) / (hi - lo)
+	if u < split {
+		return lo + math.Sqrt(u*(hi-lo)*(peak-lo))
+	}
+	return hi - math.Sqrt((1-u)*(hi-lo)*(hi-peak))
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Byte encodings accepted by bytes
+const (
+	encodingHex        = "hex"
+	encodingBase64     = "base64"
+	encodingBase64URL  = "base64url"
+	encodingBase32     = "base32"
+	encodingBase58     = "base58"
+	encodingRawEscaped = "raw-escaped"
+	encodingBinary     = "binary"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// streamChunkSize is how many random bytes are generated per write when
+// streaming to a file
+const streamChunkSize = 64 * 1024
+
+// encodeBytes encodes b for an inline response
+func encodeBytes(b []byte, encoding string) (string, error) {
+	switch encoding {
+	case encodingHex:
+		return hex.EncodeToString(b), nil
+	case encodingBase64:
+		return base64.StdEncoding.EncodeToString(b), nil
+	case encodingBase64URL:
+		return base64.RawURLEncoding.EncodeToString(b), nil
+	case encodingBase32:
+		return base32.StdEncoding.EncodeToString(b), nil
+	case encodingBase58:
+		return encodeBase58(b), nil
+	case encodingRawEscaped:
+		return escapeBytes(b), nil
+	case encodingBinary:
+		return "", fmt.Errorf("binary encoding requires a file target")
+	default:
+		return "", fmt.Errorf("unknown encoding: %s", encoding)
+	}
+}
+
+// encodeBase58 uses the Bitcoin alphabet; leading zero bytes become '1'
+func encodeBase58(b []byte) string {
+	zeros := 0
+	for zeros < len(b) && b[zeros] == 0 {
+		zeros++
+	}
+
+	n := new(big.Int).SetBytes(b)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	out = append(out, strings.Repeat("1", zeros)...)
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+// escapeBytes keeps printable ASCII as is, doubles backslashes and writes
+// every other byte as \xNN
+func escapeBytes(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		switch {
+		case c == '\\':
+			sb.WriteString(`\\`)
+		case c >= 0x20 && c < 0x7f:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, `\x%02x`, c)
+		}
+	}
+	return sb.String()
+}
+
+// openWriteRoot returns the directory bytes may write files into. Writing
+// needs both the write capability (context.write) and a write root
+// (context.write_root, relative to the document).
+func openWriteRoot(context map[string]any) (*os.Root, error) {
+	if !getBool(context, "write", false) {
+		return nil, newError(codePermissionDenied, "file output is disabled; the request context must grant write")
+	}
+	dir := getString(context, "write_root", "")
+	if dir == "" {
+		return nil, newError(codePermissionDenied, "file output needs a write root (context.write_root)")
+	}
+	root, err := os.OpenRoot(resolvePath(context, dir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write root: %v", err)
+	}
+	return root, nil
+}
+
+// resolvePath resolves a relative path against the directory of the
+// document being rendered (context.file)
+func resolvePath(context map[string]any, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	if doc := getString(context, "file", ""); doc != "" {
+		return filepath.Join(filepath.Dir(doc), path)
+	}
+	return path
+}
+
+// streamBytes writes size random bytes in the given encoding to path, which
+// must lie inside root, generating and encoding them chunk by chunk. The file
+// is written under a temporary name and renamed into place once complete.
+func streamBytes(src source, root *os.Root, path string, size int64, encoding string) error {
+	dir, err := filepath.Abs(root.Name())
+	if err != nil {
+		return fmt.Errorf("failed to open write root: %v", err)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	name, err := filepath.Rel(dir, abs)
+	if err != nil || !filepath.IsLocal(name) {
+		return newError(codePermissionDenied, "writing %s is outside the write root", path)
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	parent, base := filepath.Split(name)
+	tmp := filepath.Join(parent, "."+base+".tmp-"+hex.EncodeToString(suffix))
+	f, err := root.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return writeError(dir, path, err)
+	}
+	defer root.Remove(tmp)
+
+	if err := writeEncoded(f, src, size, encoding); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	if err := root.Rename(tmp, name); err != nil {
+		return writeError(dir, path, err)
+	}
+	return nil
+}
+
+// writeError describes a failed write. os.Root refuses to follow symlinks
+// out of the write root, so a failure on a path that resolves outside it is
+// reported as PERMISSION_DENIED.
+func writeError(dir, path string, err error) error {
+	if dir, err := filepath.EvalSymlinks(dir); err == nil {
+		real, evalErr := filepath.EvalSymlinks(filepath.Dir(path))
+		if rel, relErr := filepath.Rel(dir, real); evalErr == nil && (relErr != nil || !filepath.IsLocal(rel)) {
+			return newError(codePermissionDenied, "writing %s is outside the write root", path)
+		}
+	}
+	return fmt.Errorf("failed to write file: %v", err)
+}
+
+func writeEncoded(f *os.File, src source, size int64, encoding string) error {
+	var w io.Writer = f
+	var closer io.Closer
+
+	switch encoding {
+	case encodingHex:
+		w = hex.NewEncoder(f)
+	case encodingBase64:
+		enc := base64.NewEncoder(base64.StdEncoding, f)
+		w, closer = enc, enc
+	case encodingBase64URL:
+		enc := base64.NewEncoder(base64.RawURLEncoding, f)
+		w, closer = enc, enc
+	case encodingBase32:
+		enc := base32.NewEncoder(base32.StdEncoding, f)
+		w, closer = enc, enc
+	case encodingRawEscaped:
+		w = escapeWriter{f}
+	case encodingBinary:
+	case encodingBase58:
+		return fmt.Errorf("base58 cannot be streamed; use it for inline sizes only")
+	default:
+		return fmt.Errorf("unknown encoding: %s", encoding)
+	}
+
+	buf := make([]byte, streamChunkSize)
+	for remaining := size; remaining > 0; {
+		chunk := buf[:min(remaining, int64(len(buf)))]
+		fillBytes(src, chunk)
+		if _, err := w.Write(chunk); err != nil {
+			return fmt.Errorf("failed to write file: %v", err)
+		}
+		remaining -= int64(len(chunk))
+	}
+
+	if closer != nil {
+		if err := closer.Close(); err != nil {
+			return fmt.Errorf("failed to write file: %v", err)
+		}
+	}
+	return nil
+}
+
+// escapeWriter applies escapeBytes to everything written through it
+type escapeWriter struct {
+	w io.Writer
+}
+
+func (e escapeWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(e.w, escapeBytes(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -1,13 +1,94 @@
 package main
 
 import (
-	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/big"
+	"math"
+	"net"
+	"net/netip"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// namespace is the name this plugin is installed under
+const namespace = "random"
+
+// Error codes reported in the code field of error responses
+const (
+	codeInvalidParam     = "INVALID_PARAM"
+	codeLimitExceeded    = "LIMIT_EXCEEDED"
+	codePermissionDenied = "PERMISSION_DENIED"
+)
+
+// codedError is an error carrying a protocol error code
+type codedError struct {
+	code    string
+	message string
+}
+
+func (e *codedError) Error() string {
+	return e.message
+}
+
+func newError(code, format string, args ...any) error {
+	return &codedError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// errorCode returns the protocol code of err, or "" if it has none
+func errorCode(err error) string {
+	var ce *codedError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	return ""
+}
+
+// listUnique lists the functions that handle unique themselves, meaning
+// distinct within the returned list rather than across the render
+var listUnique = map[string]bool{"ints": true}
+
+// maxListSize bounds the length of generated lists
+const maxListSize = 10000
+
+// Size limits for bytes, inline in the response and streamed to a file
+const (
+	maxInlineBytes = 1024
+	maxFileBytes   = 1 << 30
+)
+
+// maxStringLength bounds the length of generated strings and passwords
+const maxStringLength = 4096
+
+const maxPasswordAttempts = 10000
+
+// Character classes for string and password generation
+const (
+	lowerChars  = "abcdefghijklmnopqrstuvwxyz"
+	upperChars  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitChars  = "0123456789"
+	symbolChars = "!@#$%^&*()-_=+[]{};:,.<>?/~"
+
+	// ambiguousChars are easily confused when read or typed
+	ambiguousChars = "0Oo1lI|"
+)
+
+// charsets are the named character sets accepted by string
+var charsets = map[string]string{
+	"alphanumeric": lowerChars + upperChars + digitChars,
+	"alpha":        lowerChars + upperChars,
+	"lower":        lowerChars,
+	"upper":        upperChars,
+	"numeric":      digitChars,
+	"hex":          "0123456789abcdef",
+	"base64":       upperChars + lowerChars + digitChars + "+/",
+	"base64url":    upperChars + lowerChars + digitChars + "-_",
+}
+
 type Request struct {
 	Function string         `json:"function"`
 	Params   map[string]any `json:"params"`
@@ -18,6 +99,7 @@ type Response struct {
 	Value any    `json:"value"`
 	Type  string `json:"type"`
 	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
 }
 
 func main() {
@@ -31,45 +113,151 @@ func main() {
 	var resultType string
 	var err error
 
+	rng, err = newSource(req.Params, req.Context)
+	if err != nil {
+		sendErrorCode(err.Error(), errorCode(err))
+		return
+	}
+
+	if getBool(req.Params, "unique", false) && !listUnique[req.Function] {
+		result, resultType, err = generateUnique(req, dispatch)
+	} else {
+		result, resultType, err = dispatch(req)
+	}
+
+	if err != nil {
+		sendErrorCode(err.Error(), errorCode(err))
+		return
+	}
+
+	sendResponse(result, resultType)
+}
+
+// dispatch routes a request to its handler
+func dispatch(req Request) (any, string, error) {
 	switch req.Function {
 	case "int":
-		result, resultType, err = handleInt(req.Params)
+		return handleInt(req.Params)
+	case "ints":
+		return handleInts(req.Params)
 	case "float":
-		result, resultType, err = handleFloat(req.Params)
+		return handleFloat(req.Params)
 	case "bool":
-		result, resultType, err = handleBool(req.Params)
+		return handleBool(req.Params)
 	case "choice":
-		result, resultType, err = handleChoice(req.Params)
+		return handleChoice(req.Params)
+	case "weighted":
+		return handleWeighted(req.Params)
+	case "shuffle":
+		return handleShuffle(req.Params)
+	case "sample":
+		return handleSample(req.Params)
+	case "choices":
+		return handleChoices(req.Params)
 	case "bytes":
-		result, resultType, err = handleBytes(req.Params)
+		return handleBytes(req.Params, req.Context)
+	case "string":
+		return handleString(req.Params)
+	case "password":
+		return handlePassword(req.Params)
+	case "passphrase":
+		return handlePassphrase(req.Params)
+	case "time":
+		return handleTime(req.Params)
+	case "duration":
+		return handleDuration(req.Params)
+	case "ipv4":
+		return handleIP(req.Params, 4)
+	case "ipv6":
+		return handleIP(req.Params, 6)
+	case "port":
+		return handlePort(req.Params)
+	case "mac":
+		return handleMAC(req.Params)
+	case "normal":
+		return handleNormal(req.Params)
+	case "lognormal":
+		return handleLognormal(req.Params)
+	case "exponential":
+		return handleExponential(req.Params)
+	case "poisson":
+		return handlePoisson(req.Params)
+	case "binomial":
+		return handleBinomial(req.Params)
+	case "zipf":
+		return handleZipf(req.Params)
+	case "triangular":
+		return handleTriangular(req.Params)
 	default:
-		sendError(fmt.Sprintf("Unknown function: %s", req.Function))
-		return
+		return nil, "", fmt.Errorf("Unknown function: %s", req.Function)
 	}
+}
 
+func handleInt(params map[string]any) (any, string, error) {
+	r, err := getIntRange(params)
 	if err != nil {
-		sendError(err.Error())
-		return
+		return nil, "", err
 	}
 
-	sendResponse(result, resultType)
+	return r.pick(rng), "int", nil
 }
 
-func handleInt(params map[string]any) (any, string, error) {
-	min := getInt64(params, "min", 0)
-	max := getInt64(params, "max", 100)
-
-	if min >= max {
-		return nil, "", fmt.Errorf("min must be less than max")
+func handleInts(params map[string]any) (any, string, error) {
+	count := getInt(params, "count", 10)
+	if count < 0 || count > maxListSize {
+		return nil, "", fmt.Errorf("count must be between 0 and %d", maxListSize)
 	}
 
-	diff := max - min
-	n, err := rand.Int(rand.Reader, big.NewInt(diff))
+	r, err := getIntRange(params)
 	if err != nil {
 		return nil, "", err
 	}
 
-	return min + n.Int64(), "int", nil
+	result := make([]any, count)
+
+	if !getBool(params, "unique", false) {
+		for i := range result {
+			result[i] = r.pick(rng)
+		}
+		return result, "list", nil
+	}
+
+	if !r.full && uint64(count) > r.count {
+		return nil, "", newError(codeLimitExceeded, "range only has %d distinct values, %d requested", r.count, count)
+	}
+
+	// Floyd's algorithm draws count distinct offsets in O(count)
+	seen := make(map[uint64]bool, count)
+	offsets := make([]uint64, 0, count)
+	if r.full {
+		for len(offsets) < count {
+			k := rng.Uint64()
+			if !seen[k] {
+				seen[k] = true
+				offsets = append(offsets, k)
+			}
+		}
+	} else {
+		for j := r.count - uint64(count); j < r.count; j++ {
+			k := uint64n(rng, j+1)
+			if seen[k] {
+				k = j
+			}
+			seen[k] = true
+			offsets = append(offsets, k)
+		}
+	}
+
+	// Floyd's output order is not uniform, so shuffle it
+	for i := len(offsets) - 1; i > 0; i-- {
+		j := uint64n(rng, uint64(i+1))
+		offsets[i], offsets[j] = offsets[j], offsets[i]
+	}
+	for i, k := range offsets {
+		result[i] = r.value(k)
+	}
+
+	return result, "list", nil
 }
 
 func handleFloat(params map[string]any) (any, string, error) {
@@ -80,64 +268,754 @@ func handleFloat(params map[string]any) (any, string, error) {
 		return nil, "", fmt.Errorf("min must be less than max")
 	}
 
-	// Generate random bytes and convert to float
-	b := make([]byte, 8)
-	if _, err := rand.Read(b); err != nil {
+	// Scale [0, 1) to [min, max)
+	result := min + float64n(rng)*(max-min)
+
+	return result, "float", nil
+}
+
+func handleBool(params map[string]any) (any, string, error) {
+	return rng.Uint64()&1 == 1, "bool", nil
+}
+
+func handleChoice(params map[string]any) (any, string, error) {
+	items, ok := params["items"].([]any)
+	if !ok || len(items) == 0 {
+		return nil, "", fmt.Errorf("items parameter required and must be non-empty list")
+	}
+
+	item := items[uint64n(rng, uint64(len(items)))]
+	return item, valueType(item), nil
+}
+
+func handleWeighted(params map[string]any) (any, string, error) {
+	items, weights, err := getWeightedItems(params)
+	if err != nil {
 		return nil, "", err
 	}
 
-	// Convert to uint64 then to float in range [0, 1)
-	n := uint64(0)
-	for _, v := range b {
-		n = (n << 8) | uint64(v)
+	item := items[pickWeighted(weights)]
+	return item, valueType(item), nil
+}
+
+func handleShuffle(params map[string]any) (any, string, error) {
+	items, ok := params["items"].([]any)
+	if !ok {
+		return nil, "", fmt.Errorf("items parameter required and must be a list")
 	}
 
-	// Scale to [0, 1)
-	f := float64(n) / float64(^uint64(0))
+	result := make([]any, len(items))
+	copy(result, items)
 
-	// Scale to [min, max)
-	result := min + f*(max-min)
+	// Fisher-Yates
+	for i := len(result) - 1; i > 0; i-- {
+		j := uint64n(rng, uint64(i+1))
+		result[i], result[j] = result[j], result[i]
+	}
 
-	return result, "float", nil
+	return result, "list", nil
 }
 
-func handleBool(params map[string]any) (any, string, error) {
-	b := make([]byte, 1)
-	if _, err := rand.Read(b); err != nil {
-		return nil, "", err
+func handleSample(params map[string]any) (any, string, error) {
+	items, ok := params["items"].([]any)
+	if !ok {
+		return nil, "", fmt.Errorf("items parameter required and must be a list")
+	}
+
+	k := getInt(params, "k", 1)
+	if k < 0 || k > len(items) {
+		return nil, "", fmt.Errorf("k must be between 0 and the number of items (%d)", len(items))
+	}
+
+	pool := make([]any, len(items))
+	copy(pool, items)
+
+	// Partial Fisher-Yates: the first k slots end up as the sample
+	for i := 0; i < k; i++ {
+		j := i + int(uint64n(rng, uint64(len(pool)-i)))
+		pool[i], pool[j] = pool[j], pool[i]
 	}
 
-	return b[0]&1 == 1, "bool", nil
+	return pool[:k], "list", nil
 }
 
-func handleChoice(params map[string]any) (any, string, error) {
-	items, ok := params["items"].([]any)
-	if !ok || len(items) == 0 {
-		return nil, "", fmt.Errorf("items parameter required and must be non-empty list")
+func handleChoices(params map[string]any) (any, string, error) {
+	k := getInt(params, "k", 1)
+	if k < 0 || k > maxListSize {
+		return nil, "", fmt.Errorf("k must be between 0 and %d", maxListSize)
+	}
+
+	var items []any
+	var weights []float64
+	if _, ok := params["weights"]; ok || hasWeightedBlocks(params) {
+		var err error
+		if items, weights, err = getWeightedItems(params); err != nil {
+			return nil, "", err
+		}
+	} else {
+		var ok bool
+		if items, ok = params["items"].([]any); !ok || len(items) == 0 {
+			return nil, "", fmt.Errorf("items parameter required and must be non-empty list")
+		}
+	}
+
+	result := make([]any, k)
+	for i := range result {
+		if weights != nil {
+			result[i] = items[pickWeighted(weights)]
+		} else {
+			result[i] = items[uint64n(rng, uint64(len(items)))]
+		}
+	}
+
+	return result, "list", nil
+}
+
+func handleBytes(params map[string]any, context map[string]any) (any, string, error) {
+	size := getInt64(params, "size", 16)
+	encoding := getString(params, "encoding", encodingHex)
+
+	if path := getString(params, "file", ""); path != "" {
+		if size <= 0 || size > maxFileBytes {
+			return nil, "", fmt.Errorf("size must be between 1 and %d when writing to a file", maxFileBytes)
+		}
+		root, err := openWriteRoot(context)
+		if err != nil {
+			return nil, "", err
+		}
+		defer root.Close()
+		if err := streamBytes(rng, root, resolvePath(context, path), size, encoding); err != nil {
+			return nil, "", err
+		}
+		return path, "string", nil
+	}
+
+	if size <= 0 || size > maxInlineBytes {
+		return nil, "", fmt.Errorf("size must be between 1 and %d (use file for larger sizes)", maxInlineBytes)
 	}
 
-	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(items))))
+	b := make([]byte, size)
+	fillBytes(rng, b)
+
+	encoded, err := encodeBytes(b, encoding)
 	if err != nil {
 		return nil, "", err
 	}
+	return encoded, "string", nil
+}
+
+func handleString(params map[string]any) (any, string, error) {
+	if _, ok := rng.(cryptoSource); !ok {
+		return nil, "", newError(codeInvalidParam, "string is only available in crypto mode")
+	}
 
-	return items[n.Int64()], "string", nil
+	length := getInt(params, "length", 16)
+	if length <= 0 || length > maxStringLength {
+		return nil, "", fmt.Errorf("length must be between 1 and %d", maxStringLength)
+	}
+
+	charset := getString(params, "charset", "alphanumeric")
+	chars, ok := charsets[charset]
+	if !ok {
+		// Anything that isn't a named charset is used as the characters themselves
+		chars = charset
+	}
+
+	alphabet := []rune(chars)
+	if len(alphabet) < 2 {
+		return nil, "", fmt.Errorf("charset must contain at least 2 characters")
+	}
+	// Repeated characters would be drawn more often than the others
+	seen := make(map[rune]bool, len(alphabet))
+	for _, r := range alphabet {
+		if seen[r] {
+			return nil, "", newError(codeInvalidParam, "charset contains duplicate character %q", r)
+		}
+		seen[r] = true
+	}
+
+	return randomString(rng, alphabet, length), "string", nil
 }
 
-func handleBytes(params map[string]any) (any, string, error) {
-	size := int(getInt64(params, "size", 16))
+func handlePassword(params map[string]any) (any, string, error) {
+	if _, ok := rng.(cryptoSource); !ok {
+		return nil, "", newError(codeInvalidParam, "password is only available in crypto mode")
+	}
+
+	length := getInt(params, "length", 16)
+	if length < 4 || length > maxStringLength {
+		return nil, "", fmt.Errorf("length must be between 4 and %d", maxStringLength)
+	}
+
+	excludeAmbiguous := getBool(params, "exclude_ambiguous", false)
+	requireSymbol := getBool(params, "require_symbol", false)
 
-	if size <= 0 || size > 1024 {
-		return nil, "", fmt.Errorf("size must be between 1 and 1024")
+	// Symbols only appear when required, so the default suits systems with
+	// restrictive password rules
+	classes := []struct {
+		chars    string
+		inPool   bool
+		required bool
+	}{
+		{lowerChars, true, getBool(params, "require_lower", true)},
+		{upperChars, true, getBool(params, "require_upper", true)},
+		{digitChars, true, getBool(params, "require_digit", true)},
+		{symbolChars, requireSymbol, requireSymbol},
 	}
 
-	b := make([]byte, size)
-	if _, err := rand.Read(b); err != nil {
+	var pool []rune
+	var required [][]rune
+	for _, class := range classes {
+		if !class.inPool {
+			continue
+		}
+		chars := []rune(class.chars)
+		if excludeAmbiguous {
+			chars = withoutRunes(chars, ambiguousChars)
+		}
+		pool = append(pool, chars...)
+		if class.required {
+			required = append(required, chars)
+		}
+	}
+
+	// Rejection sampling keeps every valid password equally likely, unlike
+	// placing one character per class and shuffling
+	for attempt := 0; attempt < maxPasswordAttempts; attempt++ {
+		password := randomString(rng, pool, length)
+		if containsAllClasses(password, required) {
+			return password, "string", nil
+		}
+	}
+
+	return nil, "", newError(codeLimitExceeded, "could not satisfy character class requirements")
+}
+
+func handlePassphrase(params map[string]any) (any, string, error) {
+	if _, ok := rng.(cryptoSource); !ok {
+		return nil, "", newError(codeInvalidParam, "passphrase is only available in crypto mode")
+	}
+
+	count := getInt(params, "words", 6)
+	if count <= 0 || count > 64 {
+		return nil, "", fmt.Errorf("words must be between 1 and 64")
+	}
+
+	separator := getString(params, "separator", "-")
+	capitalize := getBool(params, "capitalize", false)
+
+	wordlist := passphraseWords()
+	words := make([]string, count)
+	for i := range words {
+		word := wordlist[uint64n(rng, uint64(len(wordlist)))]
+		if capitalize {
+			word = strings.ToUpper(word[:1]) + word[1:]
+		}
+		words[i] = word
+	}
+
+	return strings.Join(words, separator), "string", nil
+}
+
+func handleTime(params map[string]any) (any, string, error) {
+	now := time.Now().UTC()
+
+	start, err := getTime(params, "start", now.AddDate(-1, 0, 0))
+	if err != nil {
+		return nil, "", err
+	}
+	end, err := getTime(params, "end", now)
+	if err != nil {
 		return nil, "", err
 	}
 
-	// Encode as hex string
-	return fmt.Sprintf("%x", b), "string", nil
+	if !start.Before(end) {
+		return nil, "", fmt.Errorf("start must be before end")
+	}
+
+	// Whole seconds, since ts values are formatted as RFC 3339
+	span := uint64(end.Unix() - start.Unix())
+	if span == 0 {
+		return start.Format(time.RFC3339), "ts", nil
+	}
+	t := time.Unix(start.Unix()+int64(uint64n(rng, span)), 0).In(start.Location())
+
+	return t.Format(time.RFC3339), "ts", nil
+}
+
+func handleDuration(params map[string]any) (any, string, error) {
+	min, err := getDuration(params, "min", 0)
+	if err != nil {
+		return nil, "", err
+	}
+	max, err := getDuration(params, "max", time.Hour)
+	if err != nil {
+		return nil, "", err
+	}
+	round, err := getDuration(params, "round", 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if min >= max {
+		return nil, "", fmt.Errorf("min must be less than max")
+	}
+
+	d := min + time.Duration(uint64n(rng, uint64(max-min)))
+	if round > 0 {
+		d = d.Round(round)
+	}
+
+	return d.String(), "dur", nil
+}
+
+func handleIP(params map[string]any, version int) (any, string, error) {
+	defaultCIDR := "0.0.0.0/0"
+	if version == 6 {
+		defaultCIDR = "::/0"
+	}
+
+	prefix, err := netip.ParsePrefix(getString(params, "cidr", defaultCIDR))
+	if err != nil {
+		return nil, "", newError(codeInvalidParam, "invalid cidr: %v", err)
+	}
+	prefix = prefix.Masked()
+
+	if (version == 4) != prefix.Addr().Is4() {
+		return nil, "", newError(codeInvalidParam, "cidr must be an IPv%d network", version)
+	}
+
+	base := prefix.Addr().AsSlice()
+	hostBits := len(base)*8 - prefix.Bits()
+
+	// In IPv4 networks with room for hosts, skip the network and broadcast
+	// addresses unless asked for any address
+	hostsOnly := version == 4 && hostBits >= 2 && getBool(params, "hosts_only", true)
+
+	for {
+		addr := make([]byte, len(base))
+		fillBytes(rng, addr)
+		for i := range addr {
+			// Keep the network bits from base and the host bits from addr
+			bitsLeft := prefix.Bits() - i*8
+			var mask byte
+			switch {
+			case bitsLeft >= 8:
+				mask = 0xff
+			case bitsLeft > 0:
+				mask = ^byte(0xff >> bitsLeft)
+			}
+			addr[i] = base[i]&mask | addr[i]&^mask
+		}
+
+		ip, _ := netip.AddrFromSlice(addr)
+		if hostsOnly && (ip == prefix.Addr() || ip == lastAddr(prefix)) {
+			continue
+		}
+		return ip.String(), "string", nil
+	}
+}
+
+func handlePort(params map[string]any) (any, string, error) {
+	spec := getString(params, "range", "1024-65535")
+
+	lo, hi, ok := strings.Cut(spec, "-")
+	if !ok {
+		hi = lo
+	}
+	min, err1 := strconv.Atoi(strings.TrimSpace(lo))
+	max, err2 := strconv.Atoi(strings.TrimSpace(hi))
+	if err1 != nil || err2 != nil || min < 1 || max > 65535 || min > max {
+		return nil, "", newError(codeInvalidParam, "range must look like 1024-65535 within 1-65535")
+	}
+
+	return min + int(uint64n(rng, uint64(max-min+1))), "int", nil
+}
+
+func handleMAC(params map[string]any) (any, string, error) {
+	mac := make([]byte, 6)
+	fillBytes(rng, mac)
+
+	if oui := getString(params, "oui", ""); oui != "" {
+		prefix, err := hex.DecodeString(strings.NewReplacer(":", "", "-", "", ".", "").Replace(oui))
+		if err != nil || len(prefix) != 3 {
+			return nil, "", newError(codeInvalidParam, "oui must be 3 bytes, e.g. 00:1A:2B")
+		}
+		copy(mac, prefix)
+	} else {
+		// Locally administered, unicast
+		mac[0] = mac[0]&^0x01 | 0x02
+	}
+
+	return net.HardwareAddr(mac).String(), "string", nil
+}
+
+func handleNormal(params map[string]any) (any, string, error) {
+	mean := getFloat64(params, "mean", 0.0)
+	stddev := getFloat64(params, "stddev", 1.0)
+
+	if stddev < 0 {
+		return nil, "", fmt.Errorf("stddev must not be negative")
+	}
+
+	return distributionResult(params, mean+stddev*normal(rng), "float")
+}
+
+func handleLognormal(params map[string]any) (any, string, error) {
+	mu := getFloat64(params, "mu", 0.0)
+	sigma := getFloat64(params, "sigma", 1.0)
+
+	if sigma < 0 {
+		return nil, "", fmt.Errorf("sigma must not be negative")
+	}
+
+	return distributionResult(params, math.Exp(mu+sigma*normal(rng)), "float")
+}
+
+func handleExponential(params map[string]any) (any, string, error) {
+	rate := getFloat64(params, "rate", 1.0)
+
+	if rate <= 0 {
+		return nil, "", fmt.Errorf("rate must be positive")
+	}
+
+	return distributionResult(params, exponential(rng)/rate, "float")
+}
+
+func handlePoisson(params map[string]any) (any, string, error) {
+	lambda := getFloat64(params, "lambda", 1.0)
+
+	if lambda < 0 || lambda > 1e15 {
+		return nil, "", fmt.Errorf("lambda must be between 0 and 1e15")
+	}
+
+	return distributionResult(params, float64(poisson(rng, lambda)), "int")
+}
+
+func handleBinomial(params map[string]any) (any, string, error) {
+	n := getInt64(params, "n", 10)
+	p := getFloat64(params, "p", 0.5)
+
+	if n < 0 {
+		return nil, "", fmt.Errorf("n must not be negative")
+	}
+	if p < 0 || p > 1 {
+		return nil, "", fmt.Errorf("p must be between 0 and 1")
+	}
+
+	return distributionResult(params, float64(binomial(rng, n, p)), "int")
+}
+
+func handleZipf(params map[string]any) (any, string, error) {
+	s := getFloat64(params, "s", 2.0)
+	v := getFloat64(params, "v", 1.0)
+	max := getInt64(params, "max", 100)
+
+	if s <= 1 {
+		return nil, "", fmt.Errorf("s must be greater than 1")
+	}
+	if v < 1 {
+		return nil, "", fmt.Errorf("v must be at least 1")
+	}
+	if max < 0 {
+		return nil, "", fmt.Errorf("max must not be negative")
+	}
+
+	return distributionResult(params, float64(zipf(rng, s, v, uint64(max))), "int")
+}
+
+func handleTriangular(params map[string]any) (any, string, error) {
+	min := getFloat64(params, "min", 0.0)
+	max := getFloat64(params, "max", 1.0)
+	peak := getFloat64(params, "peak", (min+max)/2)
+
+	if min >= max {
+		return nil, "", fmt.Errorf("min must be less than max")
+	}
+	if peak < min || peak > max {
+		return nil, "", fmt.Errorf("peak must be between min and max")
+	}
+
+	return distributionResult(params, triangular(rng, min, peak, max), "float")
+}
+
+// randomString draws length characters uniformly from alphabet
+func randomString(src source, alphabet []rune, length int) string {
+	result := make([]rune, length)
+	for i := range result {
+		result[i] = alphabet[uint64n(src, uint64(len(alphabet)))]
+	}
+	return string(result)
+}
+
+func withoutRunes(chars []rune, exclude string) []rune {
+	var result []rune
+	for _, r := range chars {
+		if !strings.ContainsRune(exclude, r) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+func containsAllClasses(s string, classes [][]rune) bool {
+	for _, class := range classes {
+		if !strings.ContainsFunc(s, func(r rune) bool { return slices.Contains(class, r) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// intRange is the set of values min, min+step, min+2*step, ... that a
+// random integer is drawn from. Arithmetic is done on uint64 so ranges
+// spanning the whole int64 domain don't overflow.
+type intRange struct {
+	min   int64
+	step  uint64
+	count uint64 // number of values, unless full
+	full  bool   // all 2^64 int64 values (count would overflow)
+}
+
+// getIntRange reads min, max, step and inclusive. max is exclusive unless
+// inclusive is set.
+func getIntRange(params map[string]any) (intRange, error) {
+	min, err := getBigInt64(params, "min", 0)
+	if err != nil {
+		return intRange{}, err
+	}
+	max, err := getBigInt64(params, "max", 100)
+	if err != nil {
+		return intRange{}, err
+	}
+
+	step := getInt64(params, "step", 1)
+	if step <= 0 {
+		return intRange{}, fmt.Errorf("step must be positive")
+	}
+
+	span := uint64(max) - uint64(min)
+	r := intRange{min: min, step: uint64(step)}
+
+	if getBool(params, "inclusive", false) {
+		if min > max {
+			return intRange{}, fmt.Errorf("min must not exceed max")
+		}
+		n := span / r.step
+		if n == math.MaxUint64 {
+			r.full = true
+		} else {
+			r.count = n + 1
+		}
+		return r, nil
+	}
+
+	if min >= max {
+		return intRange{}, fmt.Errorf("min must be less than max")
+	}
+	r.count = (span-1)/r.step + 1
+	return r, nil
+}
+
+// value returns the k-th value of the range
+func (r intRange) value(k uint64) int64 {
+	return int64(uint64(r.min) + k*r.step)
+}
+
+func (r intRange) pick(src source) int64 {
+	if r.full {
+		return int64(src.Uint64())
+	}
+	return r.value(uint64n(src, r.count))
+}
+
+// lastAddr returns the highest address in prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Addr().AsSlice()
+	for i := range addr {
+		bitsLeft := prefix.Bits() - i*8
+		switch {
+		case bitsLeft <= 0:
+			addr[i] = 0xff
+		case bitsLeft < 8:
+			addr[i] |= 0xff >> bitsLeft
+		}
+	}
+	last, _ := netip.AddrFromSlice(addr)
+	return last
+}
+
+// getWeightedItems reads items with weights either from parallel items and
+// weights lists or from items given as {value, weight} blocks.
+func getWeightedItems(params map[string]any) ([]any, []float64, error) {
+	raw, ok := params["items"].([]any)
+	if !ok || len(raw) == 0 {
+		return nil, nil, fmt.Errorf("items parameter required and must be non-empty list")
+	}
+
+	items := make([]any, len(raw))
+	weights := make([]float64, len(raw))
+
+	if rawWeights, ok := params["weights"]; ok {
+		list, ok := rawWeights.([]any)
+		if !ok || len(list) != len(raw) {
+			return nil, nil, fmt.Errorf("weights must be a list with one weight per item")
+		}
+		copy(items, raw)
+		for i, w := range list {
+			f, ok := toFloat64(w)
+			if !ok {
+				return nil, nil, fmt.Errorf("weight %d is not a number", i)
+			}
+			weights[i] = f
+		}
+	} else {
+		for i, item := range raw {
+			block, ok := item.(map[string]any)
+			if !ok {
+				return nil, nil, fmt.Errorf("weights parameter required unless items are {value, weight} blocks")
+			}
+			value, ok := block["value"]
+			if !ok {
+				return nil, nil, fmt.Errorf("item %d is missing value", i)
+			}
+			f, ok := toFloat64(block["weight"])
+			if !ok {
+				return nil, nil, fmt.Errorf("item %d is missing a numeric weight", i)
+			}
+			items[i] = value
+			weights[i] = f
+		}
+	}
+
+	total := 0.0
+	for i, w := range weights {
+		if w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			return nil, nil, fmt.Errorf("weight %d must be a non-negative number", i)
+		}
+		total += w
+	}
+	if total <= 0 || math.IsInf(total, 0) {
+		return nil, nil, fmt.Errorf("weights must have a positive finite sum")
+	}
+
+	return items, weights, nil
+}
+
+// hasWeightedBlocks reports whether items are given as {value, weight} blocks
+func hasWeightedBlocks(params map[string]any) bool {
+	items, _ := params["items"].([]any)
+	if len(items) == 0 {
+		return false
+	}
+	block, ok := items[0].(map[string]any)
+	if !ok {
+		return false
+	}
+	_, hasWeight := block["weight"]
+	return hasWeight
+}
+
+// pickWeighted returns an index with probability proportional to its weight
+func pickWeighted(weights []float64) int {
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+
+	r := float64n(rng) * total
+	last := 0
+	for i, w := range weights {
+		if w == 0 {
+			continue
+		}
+		if r < w {
+			return i
+		}
+		r -= w
+		last = i
+	}
+	// Rounding can leave r just above the final weight
+	return last
+}
+
+// valueType maps a decoded JSON value to its UP type name
+func valueType(v any) string {
+	switch val := v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case float64:
+		if val == math.Trunc(val) && math.Abs(val) < 1<<53 {
+			return "int"
+		}
+		return "float"
+	case int, int64:
+		return "int"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "block"
+	case nil:
+		return "null"
+	default:
+		return "any"
+	}
+}
+
+// distributionResult applies the optional clamp_min/clamp_max bounds and
+// the output type (int rounds to the nearest integer) to a sampled value.
+func distributionResult(params map[string]any, x float64, defaultOutput string) (any, string, error) {
+	lo, hasLo := lookupFloat64(params, "clamp_min")
+	hi, hasHi := lookupFloat64(params, "clamp_max")
+
+	if hasLo && hasHi && lo > hi {
+		return nil, "", fmt.Errorf("clamp_min must not exceed clamp_max")
+	}
+	if hasLo {
+		x = math.Max(x, lo)
+	}
+	if hasHi {
+		x = math.Min(x, hi)
+	}
+
+	switch output := getString(params, "output", defaultOutput); output {
+	case "float":
+		if math.IsInf(x, 0) {
+			return nil, "", fmt.Errorf("value out of range")
+		}
+		return x, "float", nil
+	case "int":
+		x = math.Round(x)
+		if x < math.MinInt64 || x >= math.MaxInt64 {
+			return nil, "", fmt.Errorf("value out of int range")
+		}
+		return int64(x), "int", nil
+	default:
+		return nil, "", fmt.Errorf("output must be int or float")
+	}
+}
+
+func getString(params map[string]any, key, defaultValue string) string {
+	if v, ok := params[key]; ok {
+		if s, ok := v.(string); ok {
+			return s
+		}
+	}
+	return defaultValue
+}
+
+func getInt(params map[string]any, key string, defaultValue int) int {
+	if v, ok := params[key]; ok {
+		switch val := v.(type) {
+		case float64:
+			return int(val)
+		case int:
+			return val
+		}
+	}
+	return defaultValue
 }
 
 func getInt64(params map[string]any, key string, defaultValue int64) int64 {
@@ -154,6 +1032,80 @@ func getInt64(params map[string]any, key string, defaultValue int64) int64 {
 	return defaultValue
 }
 
+// lookupFloat64 is like getFloat64 but reports whether the parameter was set
+func lookupFloat64(params map[string]any, key string) (float64, bool) {
+	if _, ok := params[key]; !ok {
+		return 0, false
+	}
+	return getFloat64(params, key, 0), true
+}
+
+// getBigInt64 reads an integer parameter that may use the full int64 range.
+// JSON numbers can't represent every int64, so decimal strings are accepted
+// for exact values, and 2^63 (the float nearest MaxInt64) maps to MaxInt64.
+func getBigInt64(params map[string]any, key string, defaultValue int64) (int64, error) {
+	v, ok := params[key]
+	if !ok {
+		return defaultValue, nil
+	}
+
+	switch val := v.(type) {
+	case string:
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return 0, newError(codeInvalidParam, "%s must be an integer between -2^63 and 2^63-1", key)
+		}
+		return n, nil
+	case float64:
+		if val != math.Trunc(val) || val < math.MinInt64 || val > 1<<63 {
+			return 0, newError(codeInvalidParam, "%s must be an integer between -2^63 and 2^63-1", key)
+		}
+		if val == 1<<63 {
+			return math.MaxInt64, nil
+		}
+		return int64(val), nil
+	}
+	return getInt64(params, key, defaultValue), nil
+}
+
+func toFloat64(v any) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	}
+	return 0, false
+}
+
+// getTime reads an RFC 3339 timestamp parameter
+func getTime(params map[string]any, key string, defaultValue time.Time) (time.Time, error) {
+	v := getString(params, key, "")
+	if v == "" {
+		return defaultValue, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, newError(codeInvalidParam, "failed to parse %s: %v", key, err)
+	}
+	return t, nil
+}
+
+// getDuration reads a duration parameter such as "1h30m"
+func getDuration(params map[string]any, key string, defaultValue time.Duration) (time.Duration, error) {
+	v := getString(params, key, "")
+	if v == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, newError(codeInvalidParam, "failed to parse %s: %v", key, err)
+	}
+	return d, nil
+}
+
 func getFloat64(params map[string]any, key string, defaultValue float64) float64 {
 	if v, ok := params[key]; ok {
 		switch val := v.(type) {
@@ -168,6 +1120,15 @@ func getFloat64(params map[string]any, key string, defaultValue float64) float64
 	return defaultValue
 }
 
+func getBool(params map[string]any, key string, defaultValue bool) bool {
+	if v, ok := params[key]; ok {
+		if b, ok := v.(bool); ok {
+			return b
+		}
+	}
+	return defaultValue
+}
+
 func sendResponse(value any, valueType string) {
 	resp := Response{
 		Value: value,
@@ -180,8 +1141,13 @@ func sendResponse(value any, valueType string) {
 }
 
 func sendError(message string) {
+	sendErrorCode(message, "")
+}
+
+func sendErrorCode(message, code string) {
 	resp := Response{
 		Error: message,
+		Code:  code,
 	}
 	if err := json.NewEncoder(os.Stdout).Encode(resp); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to encode error response: %v\n", err)
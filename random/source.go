@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	randv2 "math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Generation modes
+const (
+	modeCrypto = "crypto"
+	modeSeeded = "seeded"
+)
+
+// Seeded algorithms
+const (
+	algorithmChaCha8 = "chacha8"
+	algorithmPCG     = "pcg"
+)
+
+// source produces uniformly distributed 64-bit values. Everything else
+// (ranges, floats, shuffles) is derived here rather than through the
+// math/rand/v2 helpers, whose exact output is not guaranteed to stay the
+// same between Go releases.
+type source interface {
+	Uint64() uint64
+}
+
+// rng is the source handlers draw from, selected per request by newSource
+var rng source = cryptoSource{}
+
+// cryptoSource reads from crypto/rand
+type cryptoSource struct{}
+
+func (cryptoSource) Uint64() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand.Read never returns an error on supported platforms
+		panic(err)
+	}
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+// newSource selects the generator for a request from params.mode and
+// params.algorithm. Seeded generators are keyed from context.seed and a
+// stream name so repeated calls in one render don't all yield the same value.
+func newSource(params, context map[string]any) (source, error) {
+	mode := getString(params, "mode", modeCrypto)
+	switch mode {
+	case modeCrypto:
+		return cryptoSource{}, nil
+	case modeSeeded:
+	default:
+		return nil, newError(codeInvalidParam, "mode must be %s or %s", modeCrypto, modeSeeded)
+	}
+
+	seed, ok := getSeed(context)
+	if !ok {
+		return nil, newError(codeInvalidParam, "seeded mode requires an integer context.seed")
+	}
+
+	stream, err := streamName(context)
+	if err != nil {
+		return nil, err
+	}
+	key := seedKey(seed, stream)
+
+	switch algorithm := getString(params, "algorithm", algorithmChaCha8); algorithm {
+	case algorithmChaCha8:
+		return randv2.NewChaCha8(key), nil
+	case algorithmPCG:
+		return randv2.NewPCG(binary.LittleEndian.Uint64(key[0:8]), binary.LittleEndian.Uint64(key[8:16])), nil
+	default:
+		return nil, newError(codeInvalidParam, "algorithm must be %s or %s", algorithmChaCha8, algorithmPCG)
+	}
+}
+
+// seedKey derives the 32-byte generator key as
+// SHA-256(uint64 big-endian seed || stream).
+func seedKey(seed uint64, stream string) [32]byte {
+	buf := binary.BigEndian.AppendUint64(nil, seed)
+	return sha256.Sum256(append(buf, stream...))
+}
+
+// streamName identifies the generator for a call: context.stream if given,
+// otherwise "file:line#n", where n numbers the calls made from that line in
+// the session so each iteration of a loop gets its own sequence.
+func streamName(context map[string]any) (string, error) {
+	if stream := getString(context, "stream", ""); stream != "" {
+		return stream, nil
+	}
+	site := fmt.Sprintf("%s:%d", getString(context, "file", ""), getInt64(context, "line", 0))
+	n, err := nextCall(context, site)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s#%d", site, n), nil
+}
+
+// callState counts the seeded calls made from each call site in a session
+type callState struct {
+	Calls map[string]uint64 `json:"calls"`
+}
+
+// nextCall returns how many seeded calls site has made before this one,
+// counted in a file beside the unique state file.
+func nextCall(context map[string]any, site string) (uint64, error) {
+	path := getString(context, "state_file", "")
+	if path != "" {
+		path += ".calls"
+	} else if session := getString(context, "session", ""); session != "" {
+		path = filepath.Join(os.TempDir(), "up-ns-random-calls-"+filepath.Base(session)+".json")
+	} else {
+		return 0, newError(codeInvalidParam, "seeded mode requires context.stream, or context.session or context.state_file to number calls")
+	}
+
+	unlock, err := lockStateFile(path)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	state := &callState{}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, state); err != nil {
+			return 0, fmt.Errorf("invalid state file: %v", err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return 0, fmt.Errorf("failed to read state file: %v", err)
+	}
+	if state.Calls == nil {
+		state.Calls = map[string]uint64{}
+	}
+
+	n := state.Calls[site]
+	state.Calls[site] = n + 1
+	if err := saveCallState(path, state); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// saveCallState writes the call counts atomically, as saveUniqueState does
+func saveCallState(path string, state *callState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	return nil
+}
+
+// getSeed reads context.seed as an unsigned 64-bit integer. JSON numbers
+// above 2^53 lose precision, so string seeds are accepted too.
+func getSeed(context map[string]any) (uint64, bool) {
+	switch v := context["seed"].(type) {
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > 1<<53 {
+			return 0, false
+		}
+		return uint64(int64(v)), true
+	case int:
+		return uint64(v), true
+	case int64:
+		return uint64(v), true
+	case string:
+		if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+			return n, true
+		}
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return uint64(n), true
+		}
+	}
+	return 0, false
+}
+
+// uint64n returns a uniform value in [0, n) using Lemire's multiply-and-reject
+// method. n must be non-zero.
+func uint64n(src source, n uint64) uint64 {
+	hi, lo := bits.Mul64(src.Uint64(), n)
+	if lo < n {
+		threshold := -n % n
+		for lo < threshold {
+			hi, lo = bits.Mul64(src.Uint64(), n)
+		}
+	}
+	return hi
+}
+
+// float64n returns a uniform value in [0, 1) with 53 bits of precision
+func float64n(src source) float64 {
+	return float64(src.Uint64()>>11) / (1 << 53)
+}
+
+// fillBytes fills b from the source, eight bytes at a time
+func fillBytes(src source, b []byte) {
+	if _, ok := src.(cryptoSource); ok {
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		return
+	}
+	for i := 0; i < len(b); i += 8 {
+		var chunk [8]byte
+		binary.LittleEndian.PutUint64(chunk[:], src.Uint64())
+		copy(b[i:], chunk[:])
+	}
+}
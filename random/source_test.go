@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Seeded output is part of the namespace's contract: the same seed must
+// produce the same values in every release. These golden values match the
+// table in README.md (seed=42, stream="golden").
+var golden = []struct {
+	algorithm string
+	raw       []uint64
+	// uint64n(1000000), uint64n(6), uint64n(1<<63+5) from a fresh source
+	ranged []uint64
+	floats []float64
+	bytes  string
+}{
+	{
+		algorithm: algorithmChaCha8,
+		raw:       []uint64{0xbc8c98069bce9d00, 0x6dc0fa97185ba1b3, 0xb0f9277077c1f8a2},
+		ranged:    []uint64{736520, 2, 1952812409198939966},
+		floats:    []float64{0.7365202919134454, 0.42872587384077443},
+		bytes:     "009dce9b06988cbcb3a15b18",
+	},
+	{
+		algorithm: algorithmPCG,
+		raw:       []uint64{0x80e0b90ad0d99f65, 0x6515345eb69dce0a, 0x992e2004eafc6a53},
+		ranged:    []uint64{503428, 2, 3215036555223193681},
+		floats:    []float64{0.5034289981275378, 0.3948548060640099},
+		bytes:     "659fd9d00ab9e0800ace9db6",
+	},
+}
+
+func goldenSource(t *testing.T, algorithm string) source {
+	t.Helper()
+	src, err := newSource(
+		map[string]any{"mode": modeSeeded, "algorithm": algorithm},
+		map[string]any{"seed": float64(42), "stream": "golden"},
+	)
+	if err != nil {
+		t.Fatalf("newSource(%s): %v", algorithm, err)
+	}
+	return src
+}
+
+func TestGoldenSequences(t *testing.T) {
+	for _, g := range golden {
+		t.Run(g.algorithm, func(t *testing.T) {
+			src := goldenSource(t, g.algorithm)
+			for i, want := range g.raw {
+				if got := src.Uint64(); got != want {
+					t.Errorf("Uint64 #%d = %#x, want %#x", i, got, want)
+				}
+			}
+
+			src = goldenSource(t, g.algorithm)
+			for i, n := range []uint64{1000000, 6, 1<<63 + 5} {
+				if got := uint64n(src, n); got != g.ranged[i] {
+					t.Errorf("uint64n(%d) = %d, want %d", n, got, g.ranged[i])
+				}
+			}
+
+			src = goldenSource(t, g.algorithm)
+			for i, want := range g.floats {
+				if got := float64n(src); got != want {
+					t.Errorf("float64n #%d = %v, want %v", i, got, want)
+				}
+			}
+
+			src = goldenSource(t, g.algorithm)
+			b := make([]byte, 12)
+			fillBytes(src, b)
+			if got := hex.EncodeToString(b); got != g.bytes {
+				t.Errorf("fillBytes = %s, want %s", got, g.bytes)
+			}
+		})
+	}
+}
+
+func TestGetSeed(t *testing.T) {
+	tests := []struct {
+		seed any
+		want uint64
+		ok   bool
+	}{
+		{float64(42), 42, true},
+		{float64(-1), 1<<64 - 1, true},
+		{float64(1 << 53), 1 << 53, true},
+		{float64(1<<53) * 2, 0, false},
+		{1.5, 0, false},
+		{"42", 42, true},
+		{"18446744073709551615", 1<<64 - 1, true},
+		{"-2", 1<<64 - 2, true},
+		{"abc", 0, false},
+		{"", 0, false},
+		{nil, 0, false},
+		{true, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := getSeed(map[string]any{"seed": tt.seed})
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("getSeed(%#v) = %d, %v; want %d, %v", tt.seed, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// seededInt runs random.int in seeded mode as a request would
+func seededInt(t *testing.T, context map[string]any) any {
+	t.Helper()
+	params := map[string]any{"mode": modeSeeded, "min": float64(0), "max": float64(1000000)}
+	src, err := newSource(params, context)
+	if err != nil {
+		t.Fatalf("newSource: %v", err)
+	}
+	rng = src
+	defer func() { rng = cryptoSource{} }()
+
+	value, _, err := dispatch(Request{Function: "int", Params: params, Context: context})
+	if err != nil {
+		t.Fatalf("int: %v", err)
+	}
+	return value
+}
+
+func TestGoldenInt(t *testing.T) {
+	got := seededInt(t, map[string]any{"seed": float64(42), "stream": "golden"})
+	if got != int64(736520) {
+		t.Errorf("int(min=0, max=1000000) = %v, want 736520", got)
+	}
+}
+
+func TestSeededCallsAreNumbered(t *testing.T) {
+	run := func() []any {
+		context := map[string]any{
+			"seed":       float64(42),
+			"file":       "fixtures.up",
+			"line":       float64(3),
+			"state_file": filepath.Join(t.TempDir(), "state.json"),
+		}
+		return []any{seededInt(t, context), seededInt(t, context), seededInt(t, context)}
+	}
+
+	// Streams "fixtures.up:3#0", "#1" and "#2"; a fresh state file starts
+	// the numbering over
+	want := []any{int64(445300), int64(615367), int64(814497)}
+	for i := 0; i < 2; i++ {
+		if got := run(); !reflect.DeepEqual(got, want) {
+			t.Errorf("seeded calls from one line = %v, want %v", got, want)
+		}
+	}
+
+	_, err := newSource(map[string]any{"mode": modeSeeded}, map[string]any{"seed": float64(42)})
+	if errorCode(err) != codeInvalidParam {
+		t.Errorf("seeded call without stream or session: err = %v, want INVALID_PARAM", err)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	_ "embed"
+	"strings"
+	"sync"
+)
+
+// effLargeWordlist is the EFF large wordlist for passphrases (7776 words,
+// one "dice-roll<TAB>word" entry per line), published under CC BY 3.0 US:
+// https://www.eff.org/deeplinks/2016/07/new-wordlists-random-passphrases
+//
+//go:embed eff_large_wordlist.txt
+var effLargeWordlist string
+
+// passphraseWords returns the words of the embedded wordlist
+var passphraseWords = sync.OnceValue(func() []string {
+	lines := strings.Split(strings.TrimSpace(effLargeWordlist), "\n")
+	words := make([]string, len(lines))
+	for i, line := range lines {
+		_, word, _ := strings.Cut(line, "\t")
+		words[i] = word
+	}
+	return words
+})